@@ -0,0 +1,79 @@
+package langfuse
+
+import "context"
+
+// pendingBatch events collected by a processor, or the buffer in sync mode, until they are sent together
+type pendingBatch struct {
+	items   []eventChanItem
+	bytes   int // bytes estimated size of the batch request
+	held    int // held events at the start of the batch held back by the open circuit breaker of their destination
+	maxHeld int // maxHeld events kept while held back when other destinations are still sent to
+}
+
+// newPendingBatch creates an empty batch keeping at most maxHeld held back events
+func newPendingBatch(maxHeld int) *pendingBatch {
+	return &pendingBatch{bytes: batchEnvelopeOverhead, maxHeld: maxHeld}
+}
+
+// add appends the item and sends the batch when it reaches the configured or adapted size.
+// The pending events are sent first when the item would push the batch over the byte limit.
+// The batch is sent with ctx bounding delivery besides the events' own deadlines.
+func (l *langfuseService) add(ctx context.Context, batch *pendingBatch, item eventChanItem) FlushResult {
+	var result FlushResult
+	if l.config.MaxBatchBytes > 0 && len(batch.items) > 0 && batch.bytes+item.size > l.config.MaxBatchBytes {
+		result = l.flush(ctx, batch)
+	}
+
+	batch.items = append(batch.items, item)
+	batch.bytes += item.size
+
+	// Update queue metrics
+	l.updateQueueMetrics()
+
+	// Flush batch if it reaches the configured or adapted size
+	if len(batch.items)-batch.held >= l.batchSizer.current(l.config.BatchSize) {
+		result.add(l.flush(ctx, batch))
+	}
+	return result
+}
+
+// flush sends the pending events, keeping the ones held back by an open circuit breaker in the batch
+func (l *langfuseService) flush(ctx context.Context, batch *pendingBatch) FlushResult {
+	if len(batch.items) == 0 {
+		return FlushResult{}
+	}
+
+	items := batch.items
+	if l.config.CoalesceEvents {
+		items = l.coalesce(items)
+	}
+	result, held := l.deliver(ctx, items)
+
+	// Other destinations keep being sent to, so the events held for one are bounded
+	if overflow := len(held) - batch.maxHeld; overflow > 0 && len(l.destinations) > 1 {
+		for _, item := range held[:overflow] {
+			l.failEvent(item, ErrCircuitOpen.WithDetails(map[string]any{"destination": item.destination}))
+			result.EventsFailed++
+		}
+		held = held[overflow:]
+	}
+
+	batch.items = append(items[:0], held...)
+	batch.held = len(held)
+	batch.bytes = batchEnvelopeOverhead
+	for _, item := range held {
+		batch.bytes += item.size
+	}
+	return result
+}
+
+// abandon sends what is left before the batch is dropped, events still held back are given up on
+func (l *langfuseService) abandon(ctx context.Context, batch *pendingBatch) FlushResult {
+	result := l.flush(ctx, batch)
+	for _, item := range batch.items {
+		l.failEvent(item, ErrCircuitOpen)
+		result.EventsFailed++
+	}
+	batch.items = nil
+	return result
+}
@@ -0,0 +1,93 @@
+package langfuse
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xops-infra/GoLangfuse/config"
+)
+
+// batchSizer adapts the batch size shared by all processors to the observed batch requests:
+// it grows by about 10% while requests finish within the latency target, shrinks by a quarter
+// when they are slower and halves on timeouts and 413/5xx responses. A nil sizer keeps the configured size.
+type batchSizer struct {
+	min    int
+	max    int
+	target time.Duration
+
+	mu   sync.Mutex
+	size int
+}
+
+// newBatchSizer creates a sizer starting at BatchSize, nil when adaptive batching is disabled
+func newBatchSizer(cfg *config.Langfuse) *batchSizer {
+	if !cfg.AdaptiveBatching {
+		return nil
+	}
+	return &batchSizer{
+		min:    cfg.MinBatchSize,
+		max:    cfg.MaxBatchSize,
+		target: cfg.BatchLatencyTarget,
+		size:   min(max(cfg.BatchSize, cfg.MinBatchSize), cfg.MaxBatchSize),
+	}
+}
+
+// current returns the batch size to use, fallback when the sizer is nil
+func (s *batchSizer) current(fallback int) int {
+	if s == nil {
+		return fallback
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// observe adapts the size to the outcome of a batch request and returns the new size
+func (s *batchSizer) observe(latency time.Duration, err error) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case isOverloadError(err):
+		s.size /= 2
+	case err != nil:
+		// Failures unrelated to the batch size, such as auth errors, tell nothing about it
+	case latency > s.target:
+		s.size -= max(1, s.size/4)
+	default:
+		s.size += max(1, s.size/10)
+	}
+	s.size = min(max(s.size, s.min), s.max)
+	return s.size
+}
+
+// isOverloadError reports whether err shows the batch was too large or slow for the server:
+// a timeout, 413 Request Entity Too Large or a 5xx response
+func isOverloadError(err error) bool {
+	var langfuseErr *Error
+	if !errors.As(err, &langfuseErr) {
+		return false
+	}
+	for {
+		if langfuseErr.StatusCode == http.StatusRequestEntityTooLarge ||
+			langfuseErr.StatusCode >= httpServerErrorStart ||
+			langfuseErr.Code == ErrNetworkTimeout.Code {
+			return true
+		}
+
+		var cause *Error
+		if !errors.As(langfuseErr.Cause, &cause) {
+			break
+		}
+		langfuseErr = cause
+	}
+
+	var netErr net.Error
+	return errors.Is(langfuseErr.Cause, context.DeadlineExceeded) ||
+		(errors.As(langfuseErr.Cause, &netErr) && netErr.Timeout())
+}
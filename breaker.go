@@ -0,0 +1,148 @@
+package langfuse
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// breakerState state of the circuit breaker guarding the ingestion API
+//
+// The breaker opens after too many consecutive outage failures, rejects requests while open,
+// lets a single probe request through once the cool-down elapsed (half-open) and closes again when it succeeds.
+type breakerState int32
+
+const (
+	breakerClosed   breakerState = iota // requests are sent
+	breakerOpen                         // requests are rejected until the cool-down elapsed
+	breakerHalfOpen                     // a single probe request decides whether to close or open again
+)
+
+// String returns the human-readable name of the state
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// circuitBreaker counts consecutive outage failures of the ingestion API. A nil breaker never opens.
+type circuitBreaker struct {
+	threshold     int
+	cooldown      time.Duration
+	onStateChange func(breakerState)
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// newCircuitBreaker creates a closed breaker, nil when threshold is 0
+func newCircuitBreaker(threshold int, cooldown time.Duration, onStateChange func(breakerState)) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{
+		threshold:     threshold,
+		cooldown:      cooldown,
+		onStateChange: onStateChange,
+	}
+}
+
+// allow reports whether a request may be sent, returning ErrCircuitOpen when it may not.
+// Once the cool-down elapsed the breaker becomes half-open and lets exactly one probe request through.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if remaining := b.cooldown - time.Since(b.openedAt); remaining > 0 {
+			return ErrCircuitOpen.WithRetryAfter(remaining)
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+	case breakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of an allowed request.
+// Only server and network errors count as failures, any other outcome shows the API is reachable.
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err == nil || !isRetryableError(err) {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.failures = 0
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+// holding reports whether events should be held back because the breaker is open and cooling down
+func (b *circuitBreaker) holding() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerOpen && time.Since(b.openedAt) < b.cooldown
+}
+
+// setState moves the breaker to the given state, notifying onStateChange. Must be called with mu locked.
+func (b *circuitBreaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	if b.onStateChange != nil {
+		b.onStateChange(state)
+	}
+}
+
+// breakerExporter an Exporter rejecting batches with ErrCircuitOpen while the circuit breaker is open
+type breakerExporter struct {
+	Exporter
+	breaker *circuitBreaker
+}
+
+// Export delivers the events unless the circuit breaker is open
+func (e breakerExporter) Export(ctx context.Context, events []types.LangfuseEvent) (*BatchResult, error) {
+	if err := e.breaker.allow(); err != nil {
+		return nil, err
+	}
+	result, err := e.Exporter.Export(ctx, events)
+	e.breaker.record(err)
+	return result, err
+}
@@ -0,0 +1,60 @@
+package langfuse_test
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	langfuse "github.com/xops-infra/GoLangfuse"
+	"github.com/xops-infra/GoLangfuse/mock"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+func Test_CircuitBreaker_ShouldHoldEventsWhileOpenAndRecover(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BatchTimeout = 10 * time.Millisecond
+	cfg.CircuitBreakerThreshold = 2
+	cfg.CircuitBreakerCooldown = 200 * time.Millisecond
+
+	var requests atomic.Int32
+	var statusCode atomic.Int32
+	statusCode.Store(http.StatusServiceUnavailable)
+	subject := newTestService(t, cfg, mock.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+		requests.Add(1)
+		return newResponse(int(statusCode.Load()), "{}"), nil
+	}))
+
+	// Two failed batches open the breaker
+	for range 2 {
+		subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "outage"})
+		result, err := subject.Flush(context.TODO())
+		require.NoError(t, err)
+		assert.Equal(t, langfuse.FlushResult{EventsFailed: 1}, result)
+	}
+	assert.Equal(t, int32(2), requests.Load())
+
+	health := subject.CheckHealth(context.TODO())
+	assert.Equal(t, "open", health.CircuitBreakerState)
+	assert.Equal(t, "critical", string(health.APIHealth))
+
+	// While open nothing is sent
+	for range 3 {
+		subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "held"})
+	}
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, langfuse.FlushResult{}, result)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Once the cool-down elapsed a probe closes the breaker and the held events are sent
+	statusCode.Store(http.StatusOK)
+	require.Eventually(t, func() bool {
+		return subject.GetMetrics().EventsProcessed == 3
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "closed", subject.GetMetrics().CircuitBreakerState)
+}
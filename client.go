@@ -2,19 +2,18 @@
 package langfuse
 
 import (
-	"bytes"
 	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
 	"io"
-	"math"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
 	"github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
 
 	"github.com/xops-infra/GoLangfuse/config"
 	"github.com/xops-infra/GoLangfuse/logger"
@@ -22,11 +21,14 @@ import (
 )
 
 const (
-	eventTypeUnknown = "unknown"
-
-	retryBackoffBase     = 2    // Base for exponential backoff calculation
-	// compressionThreshold = 1024 // Compress payload if > 1KB
-	httpClientErrorStart = 400  // HTTP client error status codes start
+	eventTypeUnknown    = "unknown"
+	eventTypeTrace      = "trace-create"
+	eventTypeGeneration = "generation-create"
+	eventTypeSpan       = "span-create"
+	eventTypeScore      = "score-create"
+
+	retryBackoffBase     = 2   // Base for exponential backoff calculation
+	httpClientErrorStart = 400 // HTTP client error status codes start
 )
 
 // Client a client interface for sending events to a Langfuse server
@@ -37,9 +39,40 @@ type Client interface {
 	SendBatch(ctx context.Context, events []types.LangfuseEvent) error
 }
 
+// BatchResultClient a Client that also reports the outcome of each event of a batch.
+// The client returned by NewClient implements it, NewHTTPExporter checks for it so that only
+// rejected events are retried, other clients have their batches accepted or failed as a whole.
+type BatchResultClient interface {
+	Client
+	// SendBatchWithResult sends multiple events in a single batch to langfuse and reports the outcome of each event.
+	// An error is only returned when the request as a whole failed, rejected events are listed in the result.
+	SendBatchWithResult(ctx context.Context, events []types.LangfuseEvent) (*BatchResult, error)
+}
+
+// BatchResult per-event outcome of a batch ingestion request
+type BatchResult struct {
+	Sent   int               // Sent number of events accepted by langfuse
+	Failed map[string]*Error // Failed errors of the rejected or invalid events, keyed by event ID
+	// Latency of the request that delivered the batch, without rate limit and retry waits.
+	// Zero when no request was made or the exporter does not measure it.
+	Latency time.Duration
+}
+
+// Err returns the error of the event with the given ID, nil if it was accepted
+func (r *BatchResult) Err(id string) *Error {
+	if r == nil {
+		return nil
+	}
+	return r.Failed[id]
+}
+
 type client struct {
-	client *http.Client
-	config *config.Langfuse
+	client      *http.Client
+	config      *config.Langfuse
+	limiter     *rateLimiter
+	retries     *retryBudget
+	compression *compressor // compression of request bodies, nil when disabled
+	metrics     *MetricsCollector
 }
 
 // NewOptimizedHTTPClient creates an HTTP client optimized for Langfuse API calls
@@ -61,9 +94,19 @@ func NewClient(
 	config *config.Langfuse,
 	httpClient *http.Client,
 ) Client {
+	return newClient(config, httpClient, newRetryBudget(config.RetryBudgetRatio), nil)
+}
+
+// newClient initialise new langfuse api client spending retries from the given budget
+// and recording rate limit waits and denied retries in the metrics collector, if any
+func newClient(config *config.Langfuse, httpClient *http.Client, retries *retryBudget, metrics *MetricsCollector) *client {
 	return &client{
-		client: httpClient,
-		config: config,
+		client:      httpClient,
+		config:      config,
+		limiter:     newRateLimiter(config.RequestsPerSecond, config.EventsPerSecond),
+		retries:     retries,
+		compression: newCompressor(config),
+		metrics:     metrics,
 	}
 }
 
@@ -86,18 +129,16 @@ func (c client) Send(ctx context.Context, ingestionEvent types.LangfuseEvent) er
 		return ErrEventValidation.WithCause(err)
 	}
 
-	request := &ingestionRequest{
-		Batch: []event{
-			{
-				ID:        ingestionEvent.GetID().String(),
-				Body:      ingestionEvent,
-				Type:      eventType,
-				Timestamp: time.Now(),
-			},
-		},
+	body := newRequestBody()
+	defer body.release()
+	body.startBatch()
+	if err := body.addEvent(*ingestionEvent.GetID(), eventType, time.Now(), ingestionEvent); err != nil {
+		log.WithError(err).Error("failed to encode ingestion event")
+		return ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "json_marshal"})
 	}
+	body.endBatch()
 
-	resp, err := c.sendEventWithRetry(ctx, request)
+	resp, err := c.sendEventWithRetry(ctx, body)
 	if err != nil {
 		return err
 	}
@@ -114,70 +155,139 @@ func (c client) Send(ctx context.Context, ingestionEvent types.LangfuseEvent) er
 
 // SendBatch sends multiple events in a single batch to langfuse
 func (c client) SendBatch(ctx context.Context, events []types.LangfuseEvent) error {
+	result, err := c.SendBatchWithResult(ctx, events)
+	if err != nil {
+		return err
+	}
+
+	if len(result.Failed) > 0 {
+		return ErrBatchProcessing.WithDetails(map[string]any{
+			"event_errors": result.Failed,
+			"batch_size":   len(events),
+		})
+	}
+
+	return nil
+}
+
+// SendBatchWithResult sends multiple events in a single batch to langfuse and reports the outcome of each event.
+// Events failing validation are reported as failed without being sent, the others are sent in one request.
+func (c client) SendBatchWithResult(ctx context.Context, events []types.LangfuseEvent) (*BatchResult, error) {
 	log := logger.FromContext(ctx)
 	if strings.TrimSpace(c.config.URL) == "" {
 		log.Warn("langfuse config is not provided. no action is taken")
-		return ErrMissingURL
+		return nil, ErrMissingURL
 	}
 
+	result := &BatchResult{Failed: make(map[string]*Error)}
 	if len(events) == 0 {
-		return nil // Nothing to send
+		return result, nil // Nothing to send
+	}
+
+	// Valid events are encoded straight into the request body
+	body := newRequestBody()
+	defer body.release()
+	encodeBatch(ctx, body, events, result)
+	if body.events == 0 {
+		return result, nil
+	}
+
+	resp, err := c.sendEventWithRetry(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Errors) > 0 {
+		log.Errorf("request to langfuse returned errors in response %v", resp.Errors)
+	}
+	for _, eventErr := range resp.Errors {
+		result.Failed[eventErr.ID.String()] = eventErr.toError()
 	}
+	result.Sent = body.events - len(resp.Errors)
+	result.Latency = resp.latency
 
-	// Validate all events first
-	var batchEvents []event
+	return result, nil
+}
+
+// encodeBatch validates the events and encodes the valid ones into an ingestion request in body
+func encodeBatch(ctx context.Context, body *requestBody, events []types.LangfuseEvent, result *BatchResult) {
+	body.startBatch()
+	encodeEvents(ctx, events, result, body.addEvent)
+	body.endBatch()
+}
+
+// encodeEvents validates the events and encodes the valid ones in their ingestion envelopes with encode,
+// for requests and exporters alike. Events of unknown type, failing validation or that cannot be encoded
+// are recorded as failed in the result.
+func encodeEvents(ctx context.Context, events []types.LangfuseEvent, result *BatchResult,
+	encode func(id uuid.UUID, eventType string, timestamp time.Time, body types.LangfuseEvent) error) {
+	log := logger.FromContext(ctx)
 	for i, ingestionEvent := range events {
-		eventType := getEventType(ingestionEvent)
-		if eventType == eventTypeUnknown {
-			log.Errorf("cannot process event of 'unknown' type")
-			return ErrUnknownEventType.WithDetails(map[string]any{
-				"event_index": i,
-			})
+		eventType, ok := validateEvent(ctx, i, ingestionEvent, result)
+		if !ok {
+			continue
 		}
-
-		if _, err := govalidator.ValidateStruct(ingestionEvent); err != nil {
-			log.WithError(err).Errorf("ingestion event validation failed")
-			return ErrEventValidation.WithCause(err).WithDetails(map[string]any{
+		if err := encode(*ingestionEvent.GetID(), eventType, time.Now(), ingestionEvent); err != nil {
+			log.WithError(err).Errorf("failed to encode ingestion event")
+			result.Failed[ingestionEvent.GetID().String()] = ErrEventProcessing.WithCause(err).WithDetails(map[string]any{
+				"operation":   "json_marshal",
 				"event_index": i,
 			})
 		}
-
-		batchEvents = append(batchEvents, event{
-			ID:        ingestionEvent.GetID().String(),
-			Body:      ingestionEvent,
-			Type:      eventType,
-			Timestamp: time.Now(),
-		})
-	}
-
-	request := &ingestionRequest{
-		Batch: batchEvents,
 	}
+}
 
-	resp, err := c.sendEventWithRetry(ctx, request)
-	if err != nil {
-		return err
+// validateEvent returns the ingestion type of the event at index i of a batch.
+// Returns false and records the event as failed in the result when it is of unknown type or invalid.
+func validateEvent(ctx context.Context, i int, ingestionEvent types.LangfuseEvent, result *BatchResult) (string, bool) {
+	log := logger.FromContext(ctx)
+	eventType := getEventType(ingestionEvent)
+	if eventType == eventTypeUnknown {
+		log.Errorf("cannot process event of 'unknown' type")
+		result.Failed[ingestionEvent.GetID().String()] = ErrUnknownEventType.WithDetails(map[string]any{
+			"event_index": i,
+		})
+		return "", false
 	}
 
-	if len(resp.Errors) > 0 {
-		log.Errorf("request to langfuse returned errors in response %v", resp.Errors)
-		return ErrBatchProcessing.WithDetails(map[string]any{
-			"api_errors": resp.Errors,
-			"batch_size": len(events),
+	if _, err := govalidator.ValidateStruct(ingestionEvent); err != nil {
+		log.WithError(err).Errorf("ingestion event validation failed")
+		result.Failed[ingestionEvent.GetID().String()] = ErrEventValidation.WithCause(err).WithDetails(map[string]any{
+			"event_index": i,
 		})
+		return "", false
 	}
-
-	return nil
+	return eventType, true
 }
 
-// sendEventWithRetry sends an ingestion event to langfuse with retry logic
-func (c client) sendEventWithRetry(ctx context.Context, request *ingestionRequest) (*ingestionResponse, error) {
+// sendEventWithRetry sends an ingestion request to langfuse with retry logic, every attempt sends the same body
+func (c client) sendEventWithRetry(ctx context.Context, body *requestBody) (*ingestionResponse, error) {
+	log := logger.FromContext(ctx)
 	var lastErr error
+	delays := newBackoff(c.config)
 
 	for i := 0; i <= c.config.MaxRetries; i++ {
-		if i > 0 {
-			// Calculate exponential backoff delay
-			delay := time.Duration(math.Pow(retryBackoffBase, float64(i-1))) * c.config.RetryDelay
+		if i == 0 {
+			c.retries.deposit()
+		} else {
+			// The server asked to wait longer than we are allowed to, fail instead of shortening its wait
+			if delays.retryAfterExceeds(lastErr) {
+				log.WithError(lastErr).Warnf("retry after %s is longer than the max retry delay %s, not retrying",
+					retryAfterOf(lastErr), c.config.MaxRetryDelay)
+				break
+			}
+
+			// Retries are shared with every other request, give up when they are used up
+			if !c.retries.withdraw() {
+				log.WithError(lastErr).Warn("retry budget exhausted, not retrying")
+				if c.metrics != nil {
+					c.metrics.IncrementRetriesDenied()
+				}
+				break
+			}
+
+			// Calculate jittered exponential backoff delay, honoring Retry-After
+			delay := delays.next(i, lastErr)
 			select {
 			case <-ctx.Done():
 				return nil, ctx.Err()
@@ -185,13 +295,12 @@ func (c client) sendEventWithRetry(ctx context.Context, request *ingestionReques
 			}
 		}
 
-		resp, err := c.sendEvent(ctx, request)
+		resp, err := c.sendEvent(ctx, body)
 		if err == nil {
 			return resp, nil
 		}
 
 		lastErr = err
-		log := logger.FromContext(ctx)
 
 		// Don't retry on client errors (4xx) or non-retryable errors
 		var langfuseErr *Error
@@ -212,8 +321,8 @@ func (c client) sendEventWithRetry(ctx context.Context, request *ingestionReques
 	})
 }
 
-// sendEvent send and ingestion event to langfuse
-func (c client) sendEvent(ctx context.Context, request *ingestionRequest) (*ingestionResponse, error) {
+// sendEvent send and ingestion request to langfuse
+func (c client) sendEvent(ctx context.Context, body *requestBody) (*ingestionResponse, error) {
 	log := logger.FromContext(ctx)
 	apiPath, err := url.JoinPath(c.config.URL, "/api/public/ingestion")
 	if err != nil {
@@ -223,52 +332,22 @@ func (c client) sendEvent(ctx context.Context, request *ingestionRequest) (*inge
 		})
 	}
 
-	payload, err := json.Marshal(request)
-	if err != nil {
-		log.WithError(err).Error("failed to marshal request payload")
-		return nil, ErrEventProcessing.WithCause(err)
-	}
-
-	// Compress payload if it's large enough
-	var body io.Reader
-	var contentEncoding string
-	if len(payload) < 0 { // Compress if payload > 1KB
-		var compressedBuf bytes.Buffer
-		gzWriter := gzip.NewWriter(&compressedBuf)
-		if _, err := gzWriter.Write(payload); err != nil {
-			gzWriter.Close()
-			return nil, ErrEventProcessing.WithCause(err).WithDetails(map[string]any{
-				"operation": "compression",
-			})
-		}
-		if err := gzWriter.Close(); err != nil {
-			return nil, ErrEventProcessing.WithCause(err).WithDetails(map[string]any{
-				"operation": "compression_close",
-			})
-		}
-		body = &compressedBuf
-		contentEncoding = "gzip"
-	} else {
-		body = bytes.NewBuffer(payload)
-	}
-
-	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, apiPath, body)
+	// Wait for the client-side rate limit instead of hitting the API rate limit
+	waited, err := c.limiter.wait(ctx, body.events)
 	if err != nil {
-		log.WithError(err).Error("failed to create langfuse request")
-		return nil, ErrRequestFailed.WithCause(err)
+		return nil, err
 	}
-
-	httpRequest.SetBasicAuth(c.config.PublicKey, c.config.SecretKey)
-	httpRequest.Header.Set("Content-Type", "application/json")
-	httpRequest.Header.Set("Accept-Encoding", "gzip")
-	if contentEncoding != "" {
-		httpRequest.Header.Set("Content-Encoding", contentEncoding)
+	if waited > 0 {
+		log.Debugf("waited %s for the langfuse rate limit", waited)
+		if c.metrics != nil {
+			c.metrics.RecordRateLimitWait(waited)
+		}
 	}
 
-	resp, err := c.client.Do(httpRequest)
+	startTime := time.Now()
+	resp, err := c.post(ctx, apiPath, body)
 	if err != nil {
-		log.WithError(err).Error("request to langfuse failed")
-		return nil, ErrConnectionFailed.WithCause(err)
+		return nil, err
 	}
 
 	defer func() {
@@ -278,7 +357,11 @@ func (c client) sendEvent(ctx context.Context, request *ingestionRequest) (*inge
 	// Handle HTTP errors
 	if resp.StatusCode >= httpClientErrorStart {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, NewHTTPError(resp.StatusCode, string(bodyBytes))
+		httpErr := NewHTTPError(resp.StatusCode, string(bodyBytes))
+		if retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); retryAfter > 0 {
+			httpErr = httpErr.WithRetryAfter(retryAfter)
+		}
+		return nil, httpErr
 	}
 
 	// Handle compressed response
@@ -310,19 +393,105 @@ func (c client) sendEvent(ctx context.Context, request *ingestionRequest) (*inge
 			"response_body": string(bodyBytes),
 		})
 	}
+	response.latency = time.Since(startTime)
 	return &response, nil
 }
 
+// post sends the request body, compressed when configured and large enough.
+// A compressed body rejected with 415 Unsupported Media Type is sent again uncompressed
+// and compression stays off from then on.
+func (c client) post(ctx context.Context, apiPath string, body *requestBody) (*http.Response, error) {
+	log := logger.FromContext(ctx)
+	size := body.buf.Len()
+	if c.compression.applies(size) {
+		compressed := newRequestBody()
+		defer compressed.release()
+		if err := c.compression.compress(&compressed.buf, body.buf.Bytes()); err != nil {
+			log.WithError(err).Error("failed to compress request payload")
+			return nil, err
+		}
+
+		resp, err := c.do(ctx, apiPath, compressed, c.compression.encoding, size)
+		if err != nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+			return resp, err
+		}
+		_ = resp.Body.Close()
+		if c.compression.disable() {
+			log.Warnf("langfuse server does not accept %s compressed requests, sending uncompressed from now on", c.compression.encoding)
+		}
+	}
+	return c.do(ctx, apiPath, body, "", size)
+}
+
+// do sends the body with the given content encoding and records the bytes sent
+func (c client) do(ctx context.Context, apiPath string, body *requestBody, contentEncoding string, uncompressedSize int) (*http.Response, error) {
+	log := logger.FromContext(ctx)
+	reader := body.reader()
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, apiPath, reader)
+	if err != nil {
+		_ = reader.Close()
+		log.WithError(err).Error("failed to create langfuse request")
+		return nil, ErrRequestFailed.WithCause(err)
+	}
+	// Unknown for a pooled body, without it the request would be sent chunked
+	httpRequest.ContentLength = int64(body.buf.Len())
+
+	httpRequest.SetBasicAuth(c.config.PublicKey, c.config.SecretKey)
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Accept-Encoding", "gzip")
+	if contentEncoding != "" {
+		httpRequest.Header.Set("Content-Encoding", contentEncoding)
+	}
+
+	if c.metrics != nil {
+		c.metrics.RecordRequestBytes(uncompressedSize, body.buf.Len())
+	}
+	resp, err := c.client.Do(httpRequest)
+	if err != nil {
+		log.WithError(err).Error("request to langfuse failed")
+		return nil, ErrConnectionFailed.WithCause(err)
+	}
+	return resp, nil
+}
+
 func getEventType(ingestionEvent types.LangfuseEvent) string {
 	switch ingestionEvent.(type) {
 	case *types.TraceEvent:
-		return "trace-create"
+		return eventTypeTrace
 	case *types.GenerationEvent:
-		return "generation-create"
+		return eventTypeGeneration
 	case *types.SpanEvent:
-		return "span-create"
+		return eventTypeSpan
 	case *types.ScoreEvent:
-		return "score-create"
+		return eventTypeScore
 	}
 	return eventTypeUnknown
 }
+
+// newEventForType returns an empty event of the concrete type for the ingestion event type, nil if unknown
+func newEventForType(eventType string) types.LangfuseEvent {
+	switch eventType {
+	case eventTypeTrace:
+		return &types.TraceEvent{}
+	case eventTypeGeneration:
+		return &types.GenerationEvent{}
+	case eventTypeSpan:
+		return &types.SpanEvent{}
+	case eventTypeScore:
+		return &types.ScoreEvent{}
+	}
+	return nil
+}
+
+// decodeEvent turns a JSON encoded event body back into its concrete event type
+func decodeEvent(eventType string, body []byte) (types.LangfuseEvent, error) {
+	event := newEventForType(eventType)
+	if event == nil {
+		return nil, ErrUnknownEventType.WithDetails(map[string]any{"type": eventType})
+	}
+
+	if err := json.Unmarshal(body, event); err != nil {
+		return nil, ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "decode_event"})
+	}
+	return event, nil
+}
@@ -0,0 +1,74 @@
+package langfuse_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	langfuse "github.com/xops-infra/GoLangfuse"
+	"github.com/xops-infra/GoLangfuse/config"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// discardTransport drains and closes the request body like a real transport and accepts every batch
+type discardTransport struct{}
+
+func (discardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, _ = io.Copy(io.Discard, req.Body)
+	_ = req.Body.Close()
+	return newResponse(http.StatusOK, "{}"), nil
+}
+
+// benchmarkEvents returns generations with chat sized inputs and outputs
+func benchmarkEvents(count int) []types.LangfuseEvent {
+	traceID := uuid.New()
+	events := make([]types.LangfuseEvent, count)
+	for i := range events {
+		id := uuid.New()
+		start := time.Now()
+		events[i] = &types.GenerationEvent{
+			ID:        &id,
+			TraceID:   &traceID,
+			Name:      "chat-completion",
+			StartTime: &start,
+			Model:     "gpt-4o",
+			Input: []map[string]any{
+				{"role": "system", "content": "You are a helpful assistant answering questions about the docs."},
+				{"role": "user", "content": fmt.Sprintf("Question %d: %s", i, strings.Repeat("how does batching work? ", 10))},
+			},
+			Output:   map[string]any{"role": "assistant", "content": strings.Repeat("Events are sent in batches. ", 20)},
+			Metadata: map[string]any{"tenant": "acme", "attempt": 1},
+		}
+	}
+	return events
+}
+
+func BenchmarkSendBatch(b *testing.B) {
+	for _, compression := range []config.Compression{config.CompressionNone, config.CompressionGzip} {
+		for _, size := range []int{10, 100} {
+			b.Run(fmt.Sprintf("%s/%d", compression, size), func(b *testing.B) {
+				cfg := newTestConfig()
+				cfg.Compression = compression
+				client := langfuse.NewClient(cfg, &http.Client{Transport: discardTransport{}})
+				events := benchmarkEvents(size)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for range b.N {
+					if err := client.SendBatch(context.Background(), events); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(testing.AllocsPerRun(10, func() {
+					_ = client.SendBatch(context.Background(), events)
+				}))/float64(size), "allocs/event")
+			})
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package langfuse
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,9 +21,26 @@ type event struct {
 	Body      types.LangfuseEvent `json:"body"`
 }
 
-// ingestionRequest langfuse ingestion request, only for client internal use
-type ingestionRequest struct {
-	Batch []event `json:"batch"`
+// batchEnvelopeOverhead number of bytes an ingestion request adds around its events
+var batchEnvelopeOverhead = len(`{"batch":[]}`)
+
+// estimateEventSize returns the serialized size of the event envelope inside an ingestion request,
+// including the separating comma. It is an estimate since the event may still change until it is sent.
+func estimateEventSize(ingestionEvent types.LangfuseEvent) (int, error) {
+	envelope := event{
+		Type:      getEventType(ingestionEvent),
+		Timestamp: time.Now(),
+		Body:      ingestionEvent,
+	}
+	if id := ingestionEvent.GetID(); id != nil {
+		envelope.ID = id.String()
+	}
+
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return 0, err
+	}
+	return len(payload) + 1, nil
 }
 
 // success langfuse response for the success cases
@@ -38,8 +57,22 @@ type eventError struct {
 	Error   string    `json:"error"`
 }
 
+// toError converts the event error reported by langfuse to an *Error, keeping the status so retryability can be decided
+func (e eventError) toError() *Error {
+	message := e.Message
+	if e.Error != "" {
+		message = strings.TrimSpace(message + " " + e.Error)
+	}
+	return NewHTTPError(e.Status, message).WithDetails(map[string]any{
+		"event_id":      e.ID.String(),
+		"response_body": message,
+	})
+}
+
 // ingestionResponse api call response from langfuse
 type ingestionResponse struct {
 	Successes []success    `json:"successes"`
 	Errors    []eventError `json:"errors"`
+
+	latency time.Duration // latency of the request that returned the response
 }
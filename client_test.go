@@ -2,11 +2,14 @@ package langfuse_test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -214,3 +217,143 @@ func (c *CustomType) GetID() *uuid.UUID {
 }
 
 func (c *CustomType) SetID(*uuid.UUID) {}
+
+func Test_SendBatchWithResult_ShouldReportEachEvent(t *testing.T) {
+	httpClient := &http.Client{}
+	newClient := langfuse.NewClient(newTestConfig(), httpClient).(langfuse.BatchResultClient)
+	acceptedID, rejectedID, invalidID := uuid.New(), uuid.New(), uuid.New()
+
+	response := &http.Response{StatusCode: http.StatusMultiStatus, Body: io.NopCloser(strings.NewReader(
+		`{"successes":[{"id":"` + acceptedID.String() + `","status":201}],` +
+			`"errors":[{"id":"` + rejectedID.String() + `","status":429,"message":"rate limited"}]}`))}
+	mockTransport := mock.AddMockTransport(t, httpClient)
+	mockTransport.ExpectWith("POST", "http://localhost:3000/api/public/ingestion").Return(response, nil)
+
+	result, err := newClient.SendBatchWithResult(context.TODO(), []types.LangfuseEvent{
+		&types.TraceEvent{ID: &acceptedID, Name: "example"},
+		&types.TraceEvent{ID: &rejectedID, Name: "example"},
+		&types.ScoreEvent{ID: &invalidID},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Sent)
+	assert.Nil(t, result.Err(acceptedID.String()))
+	assert.True(t, result.Err(rejectedID.String()).IsRetryable())
+	assert.Equal(t, http.StatusTooManyRequests, result.Err(rejectedID.String()).StatusCode)
+	assert.ErrorIs(t, result.Err(invalidID.String()), langfuse.ErrEventValidation)
+}
+
+func Test_SendBatchWithResult_ShouldReportEventsThatCannotBeEncoded(t *testing.T) {
+	var batch []map[string]any
+	transport := mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var request struct {
+			Batch []map[string]any `json:"batch"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return nil, err
+		}
+		batch = request.Batch
+		return newResponse(http.StatusOK, "{}"), nil
+	})
+	newClient := langfuse.NewClient(newTestConfig(), &http.Client{Transport: transport}).(langfuse.BatchResultClient)
+	firstID, brokenID, lastID := uuid.New(), uuid.New(), uuid.New()
+
+	result, err := newClient.SendBatchWithResult(context.TODO(), []types.LangfuseEvent{
+		&types.TraceEvent{ID: &firstID, Name: "first"},
+		&types.TraceEvent{ID: &brokenID, Name: "broken", Input: make(chan int)},
+		&types.TraceEvent{ID: &lastID, Name: "last"},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Sent)
+	assert.ErrorIs(t, result.Err(brokenID.String()), langfuse.ErrEventProcessing)
+	if assert.Len(t, batch, 2) {
+		assert.Equal(t, firstID.String(), batch[0]["id"])
+		assert.Equal(t, "trace-create", batch[0]["type"])
+		assert.Equal(t, "first", batch[0]["body"].(map[string]any)["name"])
+		assert.Equal(t, lastID.String(), batch[1]["id"])
+	}
+}
+
+func Test_Send_ShouldFailWithoutRetryingWhenRetryAfterExceedsMaxRetryDelay(t *testing.T) {
+	testCases := []struct {
+		name       string
+		retryAfter string
+	}{
+		{name: "when retry after is given in seconds", retryAfter: "3600"},
+		{name: "when retry after is given as http date", retryAfter: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.MaxRetries = 1
+			cfg.RetryDelay = time.Millisecond
+			cfg.MaxRetryDelay = 100 * time.Millisecond
+			var requests atomic.Int32
+			httpClient := &http.Client{Transport: mock.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+				requests.Add(1)
+				return &http.Response{
+					StatusCode: http.StatusTooManyRequests,
+					Header:     http.Header{"Retry-After": []string{test.retryAfter}},
+					Body:       io.NopCloser(strings.NewReader("slow down")),
+				}, nil
+			})}
+			eventID := uuid.New()
+			newClient := langfuse.NewClient(cfg, httpClient)
+
+			start := time.Now()
+			err := newClient.Send(context.TODO(), &types.TraceEvent{ID: &eventID, Name: "example"})
+			elapsed := time.Since(start)
+
+			assert.ErrorIs(t, err, langfuse.ErrRequestFailed)
+			assert.Equal(t, int32(1), requests.Load())
+			assert.Less(t, elapsed, cfg.MaxRetryDelay)
+		})
+	}
+}
+
+func Test_Send_ShouldHonorTheFullRetryAfter(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.MaxRetries = 1
+	cfg.RetryDelay = time.Millisecond
+	cfg.MaxRetryDelay = 5 * time.Second
+	cfg.RetryJitter = config.RetryJitterNone
+	httpClient := &http.Client{}
+	eventID := uuid.New()
+	newClient := langfuse.NewClient(cfg, httpClient)
+
+	rateLimited := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{"1"}},
+		Body:       io.NopCloser(strings.NewReader("slow down")),
+	}
+	mockTransport := mock.AddMockTransport(t, httpClient)
+	mockTransport.ExpectWith("POST", "http://localhost:3000/api/public/ingestion").Return(rateLimited, nil)
+	mockTransport.ExpectWith("POST", "http://localhost:3000/api/public/ingestion").Return(&http.Response{Body: io.NopCloser(strings.NewReader("{}"))}, nil)
+
+	start := time.Now()
+	err := newClient.Send(context.TODO(), &types.TraceEvent{ID: &eventID, Name: "example"})
+	elapsed := time.Since(start)
+
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, elapsed, time.Second)
+}
+
+func Test_Send_ShouldStopRetryingWhenRetryBudgetIsUsedUp(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.MaxRetries = 50
+	cfg.RetryBudgetRatio = 0.01
+	var requests atomic.Int32
+	httpClient := &http.Client{Transport: mock.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+		requests.Add(1)
+		return newResponse(http.StatusServiceUnavailable, "unavailable"), nil
+	})}
+	eventID := uuid.New()
+	newClient := langfuse.NewClient(cfg, httpClient)
+
+	err := newClient.Send(context.TODO(), &types.TraceEvent{ID: &eventID, Name: "example"})
+
+	assert.ErrorIs(t, err, langfuse.ErrRequestFailed)
+	// The first attempt and the 10 retries held in reserve
+	assert.Equal(t, int32(11), requests.Load())
+}
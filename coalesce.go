@@ -0,0 +1,105 @@
+package langfuse
+
+import (
+	"reflect"
+
+	"github.com/xops-infra/GoLangfuse/logger"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// coalesce merges the items of the batch that carry the same event ID and type and go to the same
+// destination into one, last write wins per non-empty field. The merged item takes the place of the
+// first one so an observation is still sent before the events added after its creation. Items are
+// not merged when the merged event would exceed MaxBatchBytes, later updates go to the newest one.
+func (l *langfuseService) coalesce(batch []eventChanItem) []eventChanItem {
+	if len(batch) < 2 {
+		return batch
+	}
+
+	type coalesceKey struct {
+		id          string
+		kind        reflect.Type
+		destination string
+	}
+	positions := make(map[coalesceKey]int, len(batch))
+	merged := batch[:0:0]
+	for _, item := range batch {
+		id := item.event.GetID()
+		if id == nil {
+			merged = append(merged, item)
+			continue
+		}
+
+		key := coalesceKey{id: id.String(), kind: reflect.TypeOf(item.event), destination: item.destination}
+		position, ok := positions[key]
+		if !ok {
+			positions[key] = len(merged)
+			merged = append(merged, item)
+			continue
+		}
+
+		target := merged[position]
+		event, ok := mergeEvents(target.event, item.event)
+		if ok {
+			target.event = event
+			ok = l.fitsBatch(&target)
+		}
+		if !ok {
+			positions[key] = len(merged)
+			merged = append(merged, item)
+			continue
+		}
+
+		// The folded item is acknowledged in the spool together with the merged one
+		target.coalesced = append(target.coalesced, item)
+		target.priority = max(target.priority, item.priority)
+		if !item.deadline.IsZero() && (target.deadline.IsZero() || item.deadline.Before(target.deadline)) {
+			target.deadline = item.deadline
+		}
+		merged[position] = target
+		l.metricsCollector.IncrementEventsCoalesced()
+	}
+	return merged
+}
+
+// fitsBatch measures the merged item again and reports whether it still fits in a batch on its own
+func (l *langfuseService) fitsBatch(item *eventChanItem) bool {
+	if err := l.measure(item); err != nil {
+		logger.FromContext(item.ctx).WithError(err).Warnf("failed to measure coalesced langfuse event %s", item.event.GetID())
+		return false
+	}
+	return l.config.MaxBatchBytes <= 0 || item.size+batchEnvelopeOverhead <= l.config.MaxBatchBytes
+}
+
+// mergeEvents returns a copy of base with every non-empty field of update written over it.
+// The events are left untouched, false is returned when they are not pointers to the same struct type.
+func mergeEvents(base, update types.LangfuseEvent) (types.LangfuseEvent, bool) {
+	baseValue, updateValue := reflect.ValueOf(base), reflect.ValueOf(update)
+	if baseValue.Type() != updateValue.Type() || baseValue.Kind() != reflect.Pointer ||
+		baseValue.IsNil() || updateValue.IsNil() || baseValue.Elem().Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	merged := reflect.New(baseValue.Elem().Type())
+	merged.Elem().Set(baseValue.Elem())
+	for i := range updateValue.Elem().NumField() {
+		field := updateValue.Elem().Field(i)
+		target := merged.Elem().Field(i)
+		if target.CanSet() && !isEmptyValue(field) {
+			target.Set(field)
+		}
+	}
+
+	event, ok := merged.Interface().(types.LangfuseEvent)
+	return event, ok
+}
+
+// isEmptyValue reports whether the field holds no data: its zero value, or an empty map or slice
+func isEmptyValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Map, reflect.Slice:
+		return value.Len() == 0
+	default:
+		return value.IsZero()
+	}
+}
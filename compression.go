@@ -0,0 +1,82 @@
+package langfuse
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/xops-infra/GoLangfuse/config"
+)
+
+// streamEncoder a gzip or zstd encoder that can be reused for another body
+type streamEncoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// compressor compresses request bodies above a threshold with pooled encoders
+type compressor struct {
+	encoding  string // encoding the Content-Encoding of compressed bodies
+	threshold int
+	encoders  sync.Pool
+	disabled  atomic.Bool // disabled set once the server rejected a compressed body
+}
+
+// newCompressor creates the compressor for the configured algorithm, nil when compression is off
+func newCompressor(cfg *config.Langfuse) *compressor {
+	c := &compressor{encoding: string(cfg.Compression), threshold: cfg.CompressionThreshold}
+	switch cfg.Compression {
+	case config.CompressionGzip:
+		c.encoders.New = func() any {
+			return gzip.NewWriter(io.Discard)
+		}
+	case config.CompressionZstd:
+		c.encoders.New = func() any {
+			// Options are fixed and valid, so creating the encoder cannot fail
+			encoder, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1))
+			return encoder
+		}
+	default:
+		return nil
+	}
+	return c
+}
+
+// applies reports whether a body of the given size is compressed
+func (c *compressor) applies(size int) bool {
+	return c != nil && !c.disabled.Load() && size > c.threshold
+}
+
+// compress writes the compressed payload to dst
+func (c *compressor) compress(dst *bytes.Buffer, payload []byte) error {
+	encoder := c.encoders.Get().(streamEncoder)
+	defer func() {
+		// Released from the buffer before going back to the pool
+		encoder.Reset(io.Discard)
+		c.encoders.Put(encoder)
+	}()
+
+	encoder.Reset(dst)
+	if _, err := encoder.Write(payload); err != nil {
+		return ErrEventProcessing.WithCause(err).WithDetails(map[string]any{
+			"operation": "compression",
+			"encoding":  c.encoding,
+		})
+	}
+	if err := encoder.Close(); err != nil {
+		return ErrEventProcessing.WithCause(err).WithDetails(map[string]any{
+			"operation": "compression_close",
+			"encoding":  c.encoding,
+		})
+	}
+	return nil
+}
+
+// disable turns compression off for good, returns false if it already was
+func (c *compressor) disable() bool {
+	return c.disabled.CompareAndSwap(false, true)
+}
@@ -0,0 +1,115 @@
+package langfuse_test
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	langfuse "github.com/xops-infra/GoLangfuse"
+	"github.com/xops-infra/GoLangfuse/config"
+	"github.com/xops-infra/GoLangfuse/mock"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// decodeBody reads the request body, decompressing it according to its Content-Encoding
+func decodeBody(req *http.Request) ([]byte, error) {
+	switch req.Header.Get("Content-Encoding") {
+	case "gzip":
+		reader, err := gzip.NewReader(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(reader)
+	case "zstd":
+		reader, err := zstd.NewReader(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+	return io.ReadAll(req.Body)
+}
+
+func Test_Send_ShouldCompressBodiesAboveThreshold(t *testing.T) {
+	for _, compression := range []config.Compression{config.CompressionGzip, config.CompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.Compression = compression
+			cfg.CompressionThreshold = 1024
+			var encodings []string
+			var names []string
+			transport := mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				body, err := decodeBody(req)
+				if err != nil {
+					return nil, err
+				}
+				var request struct {
+					Batch []struct {
+						Body types.TraceEvent `json:"body"`
+					} `json:"batch"`
+				}
+				if err := json.Unmarshal(body, &request); err != nil {
+					return nil, err
+				}
+				encodings = append(encodings, req.Header.Get("Content-Encoding"))
+				names = append(names, request.Batch[0].Body.Name)
+				return newResponse(http.StatusOK, "{}"), nil
+			})
+			client := langfuse.NewClient(cfg, &http.Client{Transport: transport})
+
+			large := strings.Repeat("a", 4096)
+			for _, name := range []string{"small", large, large} {
+				id := uuid.New()
+				require.NoError(t, client.Send(context.TODO(), &types.TraceEvent{ID: &id, Name: name}))
+			}
+
+			assert.Equal(t, []string{"", string(compression), string(compression)}, encodings)
+			assert.Equal(t, []string{"small", large, large}, names)
+		})
+	}
+}
+
+func Test_Send_ShouldFallBackToUncompressedOnUnsupportedMediaType(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Compression = config.CompressionZstd
+	var mu sync.Mutex
+	var encodings []string
+	subject := newTestService(t, cfg, mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		encodings = append(encodings, req.Header.Get("Content-Encoding"))
+		mu.Unlock()
+		if req.Header.Get("Content-Encoding") != "" {
+			return newResponse(http.StatusUnsupportedMediaType, "unsupported encoding"), nil
+		}
+		body, _ := io.ReadAll(req.Body)
+		if !json.Valid(body) {
+			return newResponse(http.StatusBadRequest, "invalid body"), nil
+		}
+		return newResponse(http.StatusOK, "{}"), nil
+	}))
+
+	for range 2 {
+		subject.AddEvent(context.TODO(), &types.TraceEvent{Name: strings.Repeat("compressible ", 200)})
+		result, err := subject.Flush(context.TODO())
+		require.NoError(t, err)
+		assert.Equal(t, langfuse.FlushResult{EventsSent: 1}, result)
+	}
+	require.NoError(t, subject.Stop(context.TODO()))
+
+	assert.Equal(t, []string{"zstd", "", ""}, encodings, "compression stays off once rejected")
+	// Three requests were sent, only the rejected one compressed
+	metrics := subject.GetMetrics()
+	assert.Greater(t, metrics.RequestBytesUncompressed, int64(3*2600))
+	assert.Less(t, metrics.RequestBytesSent, metrics.RequestBytesUncompressed*3/4)
+}
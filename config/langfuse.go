@@ -46,8 +46,36 @@ import (
 //
 // Performance Configuration:
 //   - NumberOfEventProcessor: Number of concurrent goroutines processing events
+//   - SyncMode: Send events inline on Flush instead of from background goroutines
 //   - BatchSize: Maximum number of events to batch together
 //   - BatchTimeout: Maximum time to wait before sending a partial batch
+//   - MaxBatchBytes: Maximum estimated size of a batch request body
+//   - Compression: Algorithm request bodies are compressed with, none, gzip or zstd
+//   - CompressionThreshold: Size above which request bodies are compressed
+//   - PartitionByTrace: Send all events of a trace in order through the same processor
+//   - CoalesceEvents: Merge pending events with the same ID and type before sending
+//   - AdaptiveBatching: Grow and shrink the batch size between MinBatchSize and MaxBatchSize
+//   - BatchLatencyTarget: Batch request latency below which adaptive batches grow
+//
+// Queue Configuration:
+//   - QueueCapacity: Maximum number of events buffered in memory
+//   - OverflowPolicy: What AddEvent does when the queue is full
+//   - EnqueueTimeout: How long AddEvent waits for space with the block_timeout policy
+//   - DeliveryTimeout: How long an event may take to be delivered after it was added
+//
+// Sampling Configuration:
+//   - SampleRate: Fraction of traces sent, decided per trace ID
+//   - SampleKeepErrors: Always send error-level observations
+//
+// Payload Configuration:
+//   - MaxStringBytes: Length above which strings in Input, Output and Metadata are truncated
+//   - MaxArrayItems: Number of elements above which arrays in Input, Output and Metadata are truncated
+//
+// Spool Configuration:
+//   - SpoolDir: Directory of the disk-backed spool, empty disables spooling
+//   - SpoolMaxBytes: Maximum total size of the spool on disk
+//   - SpoolSegmentBytes: Size at which the active spool segment is rotated
+//   - DeadLetterFile: JSONL file receiving events that permanently failed to be delivered
 //
 // HTTP Configuration:
 //   - Timeout: HTTP request timeout for API calls
@@ -58,6 +86,13 @@ import (
 // Reliability Configuration:
 //   - MaxRetries: Maximum number of retry attempts for failed requests
 //   - RetryDelay: Base delay between retry attempts (uses exponential backoff)
+//   - MaxRetryDelay: Upper bound of a single retry delay, longer Retry-After fails the request
+//   - RetryJitter: How retry delays are randomized across replicas
+//   - RetryBudgetRatio: Retries allowed per first attempt, shared by all requests
+//   - CircuitBreakerThreshold: Consecutive failed requests that stop sending for a cool-down
+//   - CircuitBreakerCooldown: How long sending stays stopped before a probe request is made
+//   - RequestsPerSecond: Client-side limit of ingestion requests per second
+//   - EventsPerSecond: Client-side limit of ingested events per second
 //
 // Example environment variables:
 //
@@ -68,6 +103,8 @@ import (
 //	LANGFUSE_BATCH_SIZE=10
 //	LANGFUSE_BATCH_TIMEOUT=5s
 //	LANGFUSE_MAX_RETRIES=3
+//	LANGFUSE_QUEUE_CAPACITY=512
+//	LANGFUSE_OVERFLOW_POLICY=drop_newest
 type Langfuse struct {
 	// URL is the Langfuse server endpoint.
 	// Required. Must be a valid URL (e.g., https://api.langfuse.com).
@@ -91,6 +128,15 @@ type Langfuse struct {
 	// Environment variable: LANGFUSE_NUM_OF_EVENT_PROCESSOR
 	NumberOfEventProcessor int `envconfig:"LANGFUSE_NUM_OF_EVENT_PROCESSOR" default:"1"`
 
+	// SyncMode runs without background goroutines for serverless functions.
+	// Events are buffered and sent inline by the caller when the buffer reaches BatchSize,
+	// on Flush and on Stop. Call Flush before the end of every invocation.
+	// NumberOfEventProcessor, BatchTimeout and OverflowPolicy are ignored, an event added
+	// while QueueCapacity events are buffered is dropped.
+	// Default: false.
+	// Environment variable: LANGFUSE_SYNC_MODE
+	SyncMode bool `envconfig:"LANGFUSE_SYNC_MODE" default:"false"`
+
 	// Timeout is the HTTP request timeout for API calls.
 	// Default: 30s. Increase for slow network conditions.
 	// Environment variable: LANGFUSE_TIMEOUT
@@ -121,11 +167,57 @@ type Langfuse struct {
 	MaxRetries int `envconfig:"LANGFUSE_MAX_RETRIES" default:"3"`
 
 	// RetryDelay is the base delay between retry attempts.
-	// Actual delay uses exponential backoff: RetryDelay * (2^attempt) + jitter.
+	// Actual delay uses exponential backoff: RetryDelay * (2^attempt), randomized by RetryJitter.
 	// Default: 1s.
 	// Environment variable: LANGFUSE_RETRY_DELAY
 	RetryDelay time.Duration `envconfig:"LANGFUSE_RETRY_DELAY" default:"1s"`
 
+	// MaxRetryDelay caps a single computed retry delay. A Retry-After response header
+	// is honored in full, unless it is longer than MaxRetryDelay, then the request fails
+	// without retrying. Set to 0 to disable the cap.
+	// Default: 30s.
+	// Environment variable: LANGFUSE_MAX_RETRY_DELAY
+	MaxRetryDelay time.Duration `envconfig:"LANGFUSE_MAX_RETRY_DELAY" default:"30s"`
+
+	// RetryJitter decides how retry delays are randomized so replicas do not retry in lockstep.
+	// One of "full", "decorrelated" or "none".
+	// Default: full.
+	// Environment variable: LANGFUSE_RETRY_JITTER
+	RetryJitter RetryJitter `envconfig:"LANGFUSE_RETRY_JITTER" default:"full"`
+
+	// RetryBudgetRatio is the number of retries allowed per first attempt, shared by
+	// all requests of the service, so retries cannot multiply the load during an outage.
+	// For example 0.2 allows one retry for every five requests, on top of a small reserve.
+	// Default: 0.2. Set to 0 to only limit retries by MaxRetries.
+	// Environment variable: LANGFUSE_RETRY_BUDGET_RATIO
+	RetryBudgetRatio float64 `envconfig:"LANGFUSE_RETRY_BUDGET_RATIO" default:"0.2"`
+
+	// CircuitBreakerThreshold is the number of consecutive requests failing with
+	// a server or network error after which the circuit breaker opens and events
+	// are held in the queue or spool instead of being sent.
+	// Default: 5. Set to 0 to disable the circuit breaker.
+	// Environment variable: LANGFUSE_CIRCUIT_BREAKER_THRESHOLD
+	CircuitBreakerThreshold int `envconfig:"LANGFUSE_CIRCUIT_BREAKER_THRESHOLD" default:"5"`
+
+	// CircuitBreakerCooldown is how long the circuit breaker stays open before
+	// a single probe request is allowed through (half-open).
+	// Default: 30s.
+	// Environment variable: LANGFUSE_CIRCUIT_BREAKER_COOLDOWN
+	CircuitBreakerCooldown time.Duration `envconfig:"LANGFUSE_CIRCUIT_BREAKER_COOLDOWN" default:"30s"`
+
+	// RequestsPerSecond limits the ingestion requests sent per second, every
+	// retry counts as a request. Processors wait for the limit instead of
+	// running into the API rate limit. Up to one second worth of requests may be sent at once.
+	// Default: 0, unlimited.
+	// Environment variable: LANGFUSE_REQUESTS_PER_SECOND
+	RequestsPerSecond float64 `envconfig:"LANGFUSE_REQUESTS_PER_SECOND" default:"0"`
+
+	// EventsPerSecond limits the events sent per second across all requests.
+	// A batch larger than one second worth of events waits for the missing tokens before it is sent.
+	// Default: 0, unlimited.
+	// Environment variable: LANGFUSE_EVENTS_PER_SECOND
+	EventsPerSecond float64 `envconfig:"LANGFUSE_EVENTS_PER_SECOND" default:"0"`
+
 	// BatchSize is the maximum number of events to batch together
 	// before sending to the API. Larger batches improve throughput
 	// but increase memory usage and latency.
@@ -138,6 +230,212 @@ type Langfuse struct {
 	// Default: 5s. Lower values reduce latency but may decrease throughput.
 	// Environment variable: LANGFUSE_BATCH_TIMEOUT
 	BatchTimeout time.Duration `envconfig:"LANGFUSE_BATCH_TIMEOUT" default:"5s"`
+
+	// MaxBatchBytes is the maximum estimated size in bytes of a batch request body.
+	// A batch is sent early when the next event would exceed it, and a single event
+	// larger than the limit is rejected when added. Keep it below the server's request size limit.
+	// Default: 3500000. Zero disables the limit.
+	// Environment variable: LANGFUSE_MAX_BATCH_BYTES
+	MaxBatchBytes int `envconfig:"LANGFUSE_MAX_BATCH_BYTES" default:"3500000"`
+
+	// Compression is the algorithm batch request bodies are compressed with, "none", "gzip" or "zstd".
+	// Requests are sent uncompressed from then on if the server answers 415 Unsupported Media Type.
+	// Default: none.
+	// Environment variable: LANGFUSE_COMPRESSION
+	Compression Compression `envconfig:"LANGFUSE_COMPRESSION" default:"none"`
+
+	// CompressionThreshold is the size in bytes above which request bodies are compressed.
+	// Smaller bodies are sent as is, compressing them costs more than it saves.
+	// Default: 1024.
+	// Environment variable: LANGFUSE_COMPRESSION_THRESHOLD
+	CompressionThreshold int `envconfig:"LANGFUSE_COMPRESSION_THRESHOLD" default:"1024"`
+
+	// PartitionByTrace gives every event processor its own queue and routes events
+	// by a hash of their trace ID, so all events of a trace are sent in the order
+	// they were added, while different traces are still sent in parallel. Queues are
+	// taken in FIFO order, priorities only decide which events overflow drops first.
+	// QueueCapacity is split between the processors.
+	// Default: false.
+	// Environment variable: LANGFUSE_PARTITION_BY_TRACE
+	PartitionByTrace bool `envconfig:"LANGFUSE_PARTITION_BY_TRACE" default:"false"`
+
+	// CoalesceEvents merges events with the same ID, type and destination pending in
+	// one batch, such as a span added at start and again after End, into a single event.
+	// Later events win for every field they set, empty fields keep the earlier value.
+	// Events are kept apart when the merged event would exceed MaxBatchBytes.
+	// Default: false.
+	// Environment variable: LANGFUSE_COALESCE_EVENTS
+	CoalesceEvents bool `envconfig:"LANGFUSE_COALESCE_EVENTS" default:"false"`
+
+	// AdaptiveBatching replaces the static BatchSize with a size that grows while
+	// batch requests complete within BatchLatencyTarget and shrinks on slow requests,
+	// timeouts and 413/5xx responses. BatchSize is the starting size.
+	// Default: false.
+	// Environment variable: LANGFUSE_ADAPTIVE_BATCHING
+	AdaptiveBatching bool `envconfig:"LANGFUSE_ADAPTIVE_BATCHING" default:"false"`
+
+	// MinBatchSize is the smallest batch size adaptive batching shrinks to.
+	// Default: 1.
+	// Environment variable: LANGFUSE_MIN_BATCH_SIZE
+	MinBatchSize int `envconfig:"LANGFUSE_MIN_BATCH_SIZE" default:"1"`
+
+	// MaxBatchSize is the largest batch size adaptive batching grows to.
+	// Default: 100.
+	// Environment variable: LANGFUSE_MAX_BATCH_SIZE
+	MaxBatchSize int `envconfig:"LANGFUSE_MAX_BATCH_SIZE" default:"100"`
+
+	// BatchLatencyTarget is the batch request latency adaptive batching aims for.
+	// Batches grow while requests are faster and shrink when they are slower.
+	// Default: 1s.
+	// Environment variable: LANGFUSE_BATCH_LATENCY_TARGET
+	BatchLatencyTarget time.Duration `envconfig:"LANGFUSE_BATCH_LATENCY_TARGET" default:"1s"`
+
+	// QueueCapacity is the maximum number of events buffered in memory
+	// while waiting to be picked up by an event processor.
+	// Default: 512. Zero falls back to the default.
+	// Environment variable: LANGFUSE_QUEUE_CAPACITY
+	QueueCapacity int `envconfig:"LANGFUSE_QUEUE_CAPACITY" default:"512"`
+
+	// OverflowPolicy decides what happens to an event added while the queue is full.
+	// One of "block", "block_timeout", "drop_newest" or "drop_oldest".
+	// Default: block. Use a drop policy to keep hot paths from waiting on telemetry.
+	// Environment variable: LANGFUSE_OVERFLOW_POLICY
+	OverflowPolicy OverflowPolicy `envconfig:"LANGFUSE_OVERFLOW_POLICY" default:"block"`
+
+	// EnqueueTimeout is how long an event waits for free queue space
+	// before it is dropped when OverflowPolicy is "block_timeout".
+	// Default: 100ms.
+	// Environment variable: LANGFUSE_ENQUEUE_TIMEOUT
+	EnqueueTimeout time.Duration `envconfig:"LANGFUSE_ENQUEUE_TIMEOUT" default:"100ms"`
+
+	// DeliveryTimeout is how long an event may take to be delivered after it was added.
+	// Events are sent independently of the caller's context, expired events are failed
+	// without being sent and a batch request is cancelled at the deadline of its oldest event.
+	// Default: 0, events do not expire.
+	// Environment variable: LANGFUSE_DELIVERY_TIMEOUT
+	DeliveryTimeout time.Duration `envconfig:"LANGFUSE_DELIVERY_TIMEOUT" default:"0"`
+
+	// SampleRate is the fraction of traces sent, between 0 and 1. The decision is made by
+	// hashing the trace ID, so a trace and all of its observations and scores are kept or
+	// dropped together. Rules per trace name, environment and level are set with langfuse.WithSamplingRules.
+	// Default: 1. Zero is unset and also keeps every trace, use a rule to drop traces entirely.
+	// Environment variable: LANGFUSE_SAMPLE_RATE
+	SampleRate float64 `envconfig:"LANGFUSE_SAMPLE_RATE" default:"1"`
+
+	// SampleKeepErrors always sends error-level spans and generations, even when their trace is sampled out.
+	// Default: true.
+	// Environment variable: LANGFUSE_SAMPLE_KEEP_ERRORS
+	SampleKeepErrors bool `envconfig:"LANGFUSE_SAMPLE_KEEP_ERRORS" default:"true"`
+
+	// MaxStringBytes truncates strings inside the Input, Output and Metadata of traces, spans
+	// and generations to this many bytes, followed by a marker with the original size.
+	// Limits per event type are set with langfuse.WithTruncationLimits.
+	// Default: 0, strings are not truncated.
+	// Environment variable: LANGFUSE_MAX_STRING_BYTES
+	MaxStringBytes int `envconfig:"LANGFUSE_MAX_STRING_BYTES" default:"0"`
+
+	// MaxArrayItems truncates arrays inside the Input, Output and Metadata of traces, spans
+	// and generations to this many elements, followed by a marker with the original length.
+	// Default: 0, arrays are not truncated.
+	// Environment variable: LANGFUSE_MAX_ARRAY_ITEMS
+	MaxArrayItems int `envconfig:"LANGFUSE_MAX_ARRAY_ITEMS" default:"0"`
+
+	// SpoolDir enables a disk-backed write-ahead spool in the given directory.
+	// Events are persisted before they are queued and the ones not delivered
+	// are replayed by the next New, e.g. after a crash or a long outage.
+	// Default: empty, spooling is disabled.
+	// Environment variable: LANGFUSE_SPOOL_DIR
+	SpoolDir string `envconfig:"LANGFUSE_SPOOL_DIR"`
+
+	// SpoolMaxBytes is the maximum total size of the spool segments on disk.
+	// Events are still queued in memory, without persistence, once the limit is reached.
+	// Undelivered events holding back the oldest segment are copied forward on rotation,
+	// so the limit is only reached by events actually waiting to be delivered.
+	// Default: 268435456 (256MB). Zero falls back to the default.
+	// Environment variable: LANGFUSE_SPOOL_MAX_BYTES
+	SpoolMaxBytes int64 `envconfig:"LANGFUSE_SPOOL_MAX_BYTES" default:"268435456"`
+
+	// SpoolSegmentBytes is the size at which the active spool segment is rotated.
+	// Fully delivered segments are deleted, so smaller segments free disk space sooner.
+	// Default: 16777216 (16MB). Zero falls back to the default.
+	// Environment variable: LANGFUSE_SPOOL_SEGMENT_BYTES
+	SpoolSegmentBytes int64 `envconfig:"LANGFUSE_SPOOL_SEGMENT_BYTES" default:"16777216"`
+
+	// DeadLetterFile is the path of a JSONL file receiving events that failed
+	// to be delivered even when sent individually. They can be sent again with langfuse.Replay.
+	// Default: empty, failed events are only logged.
+	// Environment variable: LANGFUSE_DEAD_LETTER_FILE
+	DeadLetterFile string `envconfig:"LANGFUSE_DEAD_LETTER_FILE"`
+}
+
+// OverflowPolicy controls the behaviour of the event queue when it is full.
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits until there is space in the queue (default).
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowBlockTimeout waits up to EnqueueTimeout for space, then drops the new event.
+	OverflowBlockTimeout OverflowPolicy = "block_timeout"
+	// OverflowDropNewest drops the newest queued event of a lower priority, or the event being added if there is none.
+	OverflowDropNewest OverflowPolicy = "drop_newest"
+	// OverflowDropOldest drops the oldest queued event of the lowest priority, up to the priority
+	// of the new one, to make room for it. The new event is dropped if all queued events have a higher priority.
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+)
+
+// IsValid reports whether the policy is one of the supported values.
+// An empty policy is valid and behaves like OverflowBlock.
+func (p OverflowPolicy) IsValid() bool {
+	switch p {
+	case "", OverflowBlock, OverflowBlockTimeout, OverflowDropNewest, OverflowDropOldest:
+		return true
+	}
+	return false
+}
+
+// Compression the algorithm request bodies are compressed with.
+type Compression string
+
+const (
+	// CompressionNone sends request bodies uncompressed (default).
+	CompressionNone Compression = "none"
+	// CompressionGzip compresses request bodies with gzip, supported by every Langfuse server.
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd compresses request bodies with zstd, faster and smaller than gzip
+	// but only accepted by servers that support it.
+	CompressionZstd Compression = "zstd"
+)
+
+// IsValid reports whether the compression is one of the supported values.
+// An empty compression is valid and behaves like CompressionNone.
+func (c Compression) IsValid() bool {
+	switch c {
+	case "", CompressionNone, CompressionGzip, CompressionZstd:
+		return true
+	}
+	return false
+}
+
+// RetryJitter controls how the delay between retry attempts is randomized.
+type RetryJitter string
+
+const (
+	// RetryJitterFull waits a random delay between 0 and the exponential backoff (default).
+	RetryJitterFull RetryJitter = "full"
+	// RetryJitterDecorrelated waits a random delay between RetryDelay and three times the previous delay.
+	RetryJitterDecorrelated RetryJitter = "decorrelated"
+	// RetryJitterNone waits exactly the exponential backoff.
+	RetryJitterNone RetryJitter = "none"
+)
+
+// IsValid reports whether the jitter is one of the supported values.
+// An empty jitter is valid and behaves like RetryJitterFull.
+func (j RetryJitter) IsValid() bool {
+	switch j {
+	case "", RetryJitterFull, RetryJitterDecorrelated, RetryJitterNone:
+		return true
+	}
+	return false
 }
 
 // Validate performs comprehensive validation of the Langfuse configuration.
@@ -177,6 +475,79 @@ func (c *Langfuse) Validate() error {
 		return fmt.Errorf("batch size must be greater than 0")
 	}
 
+	if c.MaxBatchBytes < 0 {
+		return fmt.Errorf("max batch bytes must not be negative")
+	}
+
+	if c.AdaptiveBatching {
+		if c.MinBatchSize <= 0 || c.MaxBatchSize < c.MinBatchSize {
+			return fmt.Errorf("adaptive batching requires 0 < min batch size <= max batch size")
+		}
+		if c.BatchLatencyTarget <= 0 {
+			return fmt.Errorf("batch latency target must be greater than 0")
+		}
+	}
+
+	if c.QueueCapacity < 0 {
+		return fmt.Errorf("queue capacity must not be negative")
+	}
+
+	if !c.OverflowPolicy.IsValid() {
+		return fmt.Errorf("unsupported overflow policy %q", c.OverflowPolicy)
+	}
+
+	if !c.Compression.IsValid() {
+		return fmt.Errorf("unsupported compression %q", c.Compression)
+	}
+
+	if c.CompressionThreshold < 0 {
+		return fmt.Errorf("compression threshold must not be negative")
+	}
+
+	if c.MaxRetryDelay < 0 {
+		return fmt.Errorf("max retry delay must not be negative")
+	}
+
+	if !c.RetryJitter.IsValid() {
+		return fmt.Errorf("unsupported retry jitter %q", c.RetryJitter)
+	}
+
+	if c.RetryBudgetRatio < 0 {
+		return fmt.Errorf("retry budget ratio must not be negative")
+	}
+
+	if c.RequestsPerSecond < 0 || c.EventsPerSecond < 0 {
+		return fmt.Errorf("rate limits must not be negative")
+	}
+
+	if c.CircuitBreakerThreshold < 0 {
+		return fmt.Errorf("circuit breaker threshold must not be negative")
+	}
+
+	if c.CircuitBreakerThreshold > 0 && c.CircuitBreakerCooldown <= 0 {
+		return fmt.Errorf("circuit breaker cooldown must be greater than 0")
+	}
+
+	if c.SpoolMaxBytes < 0 || c.SpoolSegmentBytes < 0 {
+		return fmt.Errorf("spool size limits must not be negative")
+	}
+
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("sample rate must be between 0 and 1")
+	}
+
+	if c.DeliveryTimeout < 0 {
+		return fmt.Errorf("delivery timeout must not be negative")
+	}
+
+	if c.MaxStringBytes < 0 || c.MaxArrayItems < 0 {
+		return fmt.Errorf("payload truncation limits must not be negative")
+	}
+
+	if c.OverflowPolicy == OverflowBlockTimeout && c.EnqueueTimeout <= 0 {
+		return fmt.Errorf("enqueue timeout must be greater than 0 for the %s overflow policy", OverflowBlockTimeout)
+	}
+
 	return nil
 }
 
@@ -201,8 +572,19 @@ func (c *Langfuse) Validate() error {
 //   - LANGFUSE_NUM_OF_EVENT_PROCESSOR: Number of worker goroutines (default: 1)
 //   - LANGFUSE_BATCH_SIZE: Events per batch (default: 10)
 //   - LANGFUSE_BATCH_TIMEOUT: Max batch wait time (default: 5s)
+//   - LANGFUSE_ADAPTIVE_BATCHING: Adapt the batch size to latency and errors (default: false)
 //   - LANGFUSE_MAX_RETRIES: Retry attempts (default: 3)
+//   - LANGFUSE_MAX_RETRY_DELAY: Upper bound of a retry delay (default: 30s)
+//   - LANGFUSE_RETRY_JITTER: Retry delay randomization (default: full)
+//   - LANGFUSE_RETRY_BUDGET_RATIO: Retries allowed per first attempt (default: 0.2)
+//   - LANGFUSE_CIRCUIT_BREAKER_THRESHOLD: Failures opening the circuit breaker (default: 5)
+//   - LANGFUSE_REQUESTS_PER_SECOND / LANGFUSE_EVENTS_PER_SECOND: Client-side rate limits (default: unlimited)
 //   - LANGFUSE_TIMEOUT: HTTP timeout (default: 30s)
+//   - LANGFUSE_MAX_STRING_BYTES / LANGFUSE_MAX_ARRAY_ITEMS: Payload truncation limits (default: disabled)
+//   - LANGFUSE_QUEUE_CAPACITY: In-memory queue size (default: 512)
+//   - LANGFUSE_OVERFLOW_POLICY: Behaviour when the queue is full (default: block)
+//   - LANGFUSE_SPOOL_DIR: Directory of the disk-backed spool (default: disabled)
+//   - LANGFUSE_DEAD_LETTER_FILE: JSONL file for permanently failed events (default: disabled)
 //   - And others...
 //
 // Returns a validated Langfuse configuration ready for use, or an error
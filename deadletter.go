@@ -0,0 +1,172 @@
+package langfuse
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xops-infra/GoLangfuse/logger"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// maxDeadLetterLineBytes is the longest dead letter line Replay is able to read
+const maxDeadLetterLineBytes = 64 << 20
+
+// DeadLetter an event that could not be delivered to langfuse, even when sent individually
+type DeadLetter struct {
+	Event       types.LangfuseEvent // Event the original event
+	EventType   string              // EventType the ingestion type of the event, e.g. trace-create
+	Destination string              // Destination the name of the project the event was routed to
+	Err         *Error              // Err the final error returned for the event
+	FailedAt    time.Time           // FailedAt when the event was given up on
+}
+
+// DeadLetterSink receives events that permanently failed to be delivered.
+// Implementations must be safe for concurrent use by multiple event processors.
+type DeadLetterSink interface {
+	// Write stores the dead letter, returning an error if it could not be stored
+	Write(ctx context.Context, letter DeadLetter) error
+}
+
+// deadLetterRecord a dead letter as written by FileDeadLetterSink, one JSON document per line
+type deadLetterRecord struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Body        json.RawMessage `json:"body"`
+	Destination string          `json:"destination,omitempty"`
+	Error       *Error          `json:"error,omitempty"`
+	Cause       string          `json:"cause,omitempty"`
+	FailedAt    time.Time       `json:"failedAt"`
+}
+
+// FileDeadLetterSink a DeadLetterSink appending dead letters as JSON lines to a file
+type FileDeadLetterSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileDeadLetterSink opens or creates the JSONL file at path for appending dead letters
+func NewFileDeadLetterSink(path string) (*FileDeadLetterSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600) //nolint:gosec // path is provided by the user
+	if err != nil {
+		return nil, ErrInvalidConfig.WithCause(err).WithDetails(map[string]any{"dead_letter_file": path})
+	}
+	return &FileDeadLetterSink{file: file}, nil
+}
+
+// Write appends the dead letter as a single JSON line
+func (s *FileDeadLetterSink) Write(_ context.Context, letter DeadLetter) error {
+	body, err := json.Marshal(letter.Event)
+	if err != nil {
+		return ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "dead_letter_encode"})
+	}
+
+	record := deadLetterRecord{
+		Type:        letter.EventType,
+		Destination: letter.Destination,
+		Body:        body,
+		Error:       letter.Err,
+		FailedAt:    letter.FailedAt,
+	}
+	if id := letter.Event.GetID(); id != nil {
+		record.ID = id.String()
+	}
+	if letter.Err != nil && letter.Err.Cause != nil {
+		record.Cause = letter.Err.Cause.Error()
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "dead_letter_encode"})
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(line); err != nil {
+		return ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "dead_letter_write"})
+	}
+	return nil
+}
+
+// Close closes the underlying file
+func (s *FileDeadLetterSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// Replay reads dead letters written by FileDeadLetterSink from r and submits them to the service again,
+// typically once the problem that made them fail is fixed. Events are routed to the destination they failed for.
+// Lines that cannot be decoded are logged and skipped.
+// Returns the number of events resubmitted, stopping at the first event the service does not accept.
+//
+// Example:
+//
+//	file, _ := os.Open("/var/lib/myapp/langfuse-dead-letters.jsonl")
+//	defer file.Close()
+//	replayed, err := langfuse.Replay(ctx, service, file)
+func Replay(ctx context.Context, service Langfuse, r io.Reader) (int, error) {
+	log := logger.FromContext(ctx)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxDeadLetterLineBytes)
+
+	replayed := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record deadLetterRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.WithError(err).Warnf("skipping unreadable dead letter on line %d", line)
+			continue
+		}
+
+		event, err := decodeEvent(record.Type, record.Body)
+		if err != nil {
+			log.WithError(err).Warnf("skipping undecodable dead letter on line %d", line)
+			continue
+		}
+
+		eventCtx := ctx
+		if record.Destination != "" && record.Destination != DefaultDestination {
+			eventCtx = ContextWithDestination(ctx, record.Destination)
+		}
+		if _, err := service.SubmitEvent(eventCtx, event); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return replayed, ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "dead_letter_read"})
+	}
+	return replayed, nil
+}
+
+// deadLetter hands a permanently failed event to the dead letter sink and reports whether it was stored
+func (l *langfuseService) deadLetter(item eventChanItem, err error) bool {
+	if l.deadLetterSink == nil {
+		return false
+	}
+
+	letter := DeadLetter{
+		Event:       item.event,
+		EventType:   getEventType(item.event),
+		Destination: item.destination,
+		Err:         WrapError(err, ErrEventProcessing),
+		FailedAt:    time.Now().UTC(),
+	}
+	if writeErr := l.deadLetterSink.Write(item.ctx, letter); writeErr != nil {
+		logger.FromContext(item.ctx).WithError(writeErr).Errorf("failed to dead-letter langfuse event %s", item.event.GetID())
+		return false
+	}
+
+	l.metricsCollector.IncrementEventsDeadLettered()
+	return true
+}
@@ -0,0 +1,49 @@
+package langfuse_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	langfuse "github.com/xops-infra/GoLangfuse"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+func Test_DeadLetter_ShouldStoreFailedEventsAndReplayThem(t *testing.T) {
+	deadLetterFile := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	cfg := newTestConfig()
+	cfg.DeadLetterFile = deadLetterFile
+	subject := newTestService(t, cfg, statusTransport(http.StatusBadRequest))
+
+	traceID := subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "rejected"})
+	require.NotNil(t, traceID)
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, langfuse.FlushResult{EventsFailed: 1}, result)
+	assert.Equal(t, int64(1), subject.GetMetrics().EventsDeadLettered)
+	require.NoError(t, subject.Stop(context.TODO()))
+
+	content, err := os.ReadFile(deadLetterFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"id":"`+traceID.String()+`"`)
+	assert.Contains(t, string(content), `"type":"trace-create"`)
+	assert.Contains(t, string(content), `"code":"REQUEST_FAILED"`)
+
+	// Replay into a service that accepts the events
+	cfg.DeadLetterFile = ""
+	subject = newTestService(t, cfg, statusTransport(http.StatusOK))
+
+	replayed, err := langfuse.Replay(context.TODO(), subject, bytes.NewReader(content))
+	require.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+
+	result, err = subject.Flush(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, langfuse.FlushResult{EventsSent: 1}, result)
+}
@@ -0,0 +1,152 @@
+package langfuse
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// maxPooledBodyBytes bodies grown beyond this size are left to the garbage collector
+// instead of keeping the memory of an unusually large batch around
+const maxPooledBodyBytes = 4 << 20
+
+var bodyPool = sync.Pool{
+	New: func() any {
+		body := &requestBody{}
+		body.encoder = json.NewEncoder(&body.buf)
+		return body
+	},
+}
+
+// requestBody a pooled ingestion request body events are encoded into one at a time.
+// The body is shared by the attempts sending it and goes back to the pool once its owner
+// and every request reading it released it, since a transport may still be writing a request
+// body after the response arrived.
+type requestBody struct {
+	buf     bytes.Buffer
+	encoder *json.Encoder
+	events  int // events number of events encoded into the batch
+	refs    atomic.Int32
+}
+
+// newRequestBody returns an empty body from the pool, owned by the caller until released
+func newRequestBody() *requestBody {
+	body := bodyPool.Get().(*requestBody)
+	body.refs.Store(1)
+	return body
+}
+
+// release gives up a reference to the body, the last one returns it to the pool
+func (b *requestBody) release() {
+	if b.refs.Add(-1) != 0 {
+		return
+	}
+	if b.buf.Cap() > maxPooledBodyBytes {
+		return
+	}
+	b.buf.Reset()
+	b.events = 0
+	bodyPool.Put(b)
+}
+
+// startBatch starts an ingestion request, see batchEnvelopeOverhead
+func (b *requestBody) startBatch() {
+	b.buf.WriteString(`{"batch":[`)
+}
+
+// addEvent encodes the event in its envelope into the batch, leaving the batch unchanged when it cannot be encoded
+func (b *requestBody) addEvent(id uuid.UUID, eventType string, timestamp time.Time, body types.LangfuseEvent) error {
+	mark := b.buf.Len()
+	if b.events > 0 {
+		b.buf.WriteByte(',')
+	}
+	if err := b.writeEnvelope(id, eventType, timestamp, body); err != nil {
+		b.buf.Truncate(mark)
+		return err
+	}
+	b.events++
+	return nil
+}
+
+// addLine encodes the event in its envelope as a line of JSON, for bodies written to files instead of sent
+func (b *requestBody) addLine(id uuid.UUID, eventType string, timestamp time.Time, body types.LangfuseEvent) error {
+	mark := b.buf.Len()
+	if err := b.writeEnvelope(id, eventType, timestamp, body); err != nil {
+		b.buf.Truncate(mark)
+		return err
+	}
+	b.buf.WriteByte('\n')
+	b.events++
+	return nil
+}
+
+// writeEnvelope encodes the event in its ingestion envelope. The envelope is written directly,
+// the same as encoding an event value, so only the body goes through the encoder.
+func (b *requestBody) writeEnvelope(id uuid.UUID, eventType string, timestamp time.Time, body types.LangfuseEvent) error {
+	envelope := b.buf.AvailableBuffer()
+	envelope = append(envelope, `{"id":"`...)
+	envelope = appendUUID(envelope, id)
+	envelope = append(envelope, `","type":"`...)
+	envelope = append(envelope, eventType...)
+	envelope = append(envelope, `","timestamp":"`...)
+	envelope = timestamp.AppendFormat(envelope, time.RFC3339Nano)
+	envelope = append(envelope, `","body":`...)
+	b.buf.Write(envelope)
+
+	if err := b.encoder.Encode(body); err != nil {
+		return err
+	}
+	// Replace the newline the encoder ends every value with
+	b.buf.Truncate(b.buf.Len() - 1)
+	b.buf.WriteByte('}')
+	return nil
+}
+
+// endBatch completes the ingestion request
+func (b *requestBody) endBatch() {
+	b.buf.WriteString("]}")
+}
+
+// reader returns a reader of the body for one request, keeping the body alive until it is closed
+func (b *requestBody) reader() io.ReadCloser {
+	b.refs.Add(1)
+	r := &bodyReader{body: b}
+	r.Reset(b.buf.Bytes())
+	return r
+}
+
+// bodyReader reads a request body and releases it once closed by the transport
+type bodyReader struct {
+	bytes.Reader
+	body   *requestBody
+	closed atomic.Bool
+}
+
+// Close releases the body, only the first call has an effect
+func (r *bodyReader) Close() error {
+	if r.closed.CompareAndSwap(false, true) {
+		r.body.release()
+	}
+	return nil
+}
+
+// appendUUID appends the canonical text form of the ID without allocating
+func appendUUID(dst []byte, id uuid.UUID) []byte {
+	dst = hex.AppendEncode(dst, id[0:4])
+	dst = append(dst, '-')
+	dst = hex.AppendEncode(dst, id[4:6])
+	dst = append(dst, '-')
+	dst = hex.AppendEncode(dst, id[6:8])
+	dst = append(dst, '-')
+	dst = hex.AppendEncode(dst, id[8:10])
+	dst = append(dst, '-')
+	return hex.AppendEncode(dst, id[10:])
+}
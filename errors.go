@@ -34,6 +34,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 const (
@@ -49,14 +50,17 @@ var (
 	ErrMissingSecretKey = &Error{Code: "MISSING_SECRET_KEY", Message: "langfuse secret key is required", Type: ErrorTypeConfig}
 
 	// Validation errors
-	ErrEventValidation  = &Error{Code: "EVENT_VALIDATION", Message: "event validation failed", Type: ErrorTypeValidation}
-	ErrUnknownEventType = &Error{Code: "UNKNOWN_EVENT_TYPE", Message: "unknown event type", Type: ErrorTypeValidation}
-	ErrInvalidEventID   = &Error{Code: "INVALID_EVENT_ID", Message: "invalid event ID", Type: ErrorTypeValidation}
+	ErrEventValidation    = &Error{Code: "EVENT_VALIDATION", Message: "event validation failed", Type: ErrorTypeValidation}
+	ErrUnknownEventType   = &Error{Code: "UNKNOWN_EVENT_TYPE", Message: "unknown event type", Type: ErrorTypeValidation}
+	ErrInvalidEventID     = &Error{Code: "INVALID_EVENT_ID", Message: "invalid event ID", Type: ErrorTypeValidation}
+	ErrEventTooLarge      = &Error{Code: "EVENT_TOO_LARGE", Message: "event exceeds the maximum batch size", Type: ErrorTypeValidation}
+	ErrUnknownDestination = &Error{Code: "UNKNOWN_DESTINATION", Message: "event is routed to an unknown langfuse destination", Type: ErrorTypeValidation}
 
 	// Network errors
 	ErrNetworkTimeout   = &Error{Code: "NETWORK_TIMEOUT", Message: "network request timed out", Type: ErrorTypeNetwork}
 	ErrConnectionFailed = &Error{Code: "CONNECTION_FAILED", Message: "failed to connect to langfuse", Type: ErrorTypeNetwork}
 	ErrRequestFailed    = &Error{Code: "REQUEST_FAILED", Message: "HTTP request failed", Type: ErrorTypeNetwork}
+	ErrCircuitOpen      = &Error{Code: "CIRCUIT_OPEN", Message: "langfuse circuit breaker is open", Type: ErrorTypeNetwork}
 
 	// API errors
 	ErrAPIUnauthorized = &Error{Code: "UNAUTHORIZED", Message: "unauthorized access to langfuse API", Type: ErrorTypeAPI}
@@ -69,6 +73,10 @@ var (
 	ErrBatchProcessing = &Error{Code: "BATCH_PROCESSING", Message: "batch processing failed", Type: ErrorTypeProcessing}
 	ErrEventProcessing = &Error{Code: "EVENT_PROCESSING", Message: "event processing failed", Type: ErrorTypeProcessing}
 	ErrServiceStopped  = &Error{Code: "SERVICE_STOPPED", Message: "langfuse service is stopped", Type: ErrorTypeProcessing}
+	ErrQueueFull       = &Error{Code: "QUEUE_FULL", Message: "langfuse event queue is full", Type: ErrorTypeProcessing}
+	ErrDeliveryTimeout = &Error{Code: "DELIVERY_TIMEOUT", Message: "langfuse event was not delivered in time", Type: ErrorTypeProcessing}
+	ErrSpoolFull       = &Error{Code: "SPOOL_FULL", Message: "langfuse event spool is full", Type: ErrorTypeProcessing}
+	ErrEventDropped    = &Error{Code: "EVENT_DROPPED", Message: "langfuse event was dropped by an interceptor", Type: ErrorTypeProcessing}
 )
 
 // ErrorType represents the category of error
@@ -90,6 +98,7 @@ type Error struct {
 	Details    map[string]any `json:"details,omitempty"`
 	Cause      error          `json:"-"`
 	StatusCode int            `json:"status_code,omitempty"`
+	RetryAfter time.Duration  `json:"retry_after,omitempty"`
 }
 
 // Error implements the error interface
@@ -100,6 +109,16 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("%s: %s", e.Code, e.Message)
 }
 
+// Is reports whether target is an *Error with the same code, so errors.Is matches
+// the predefined errors even after WithCause, WithDetails or WithStatusCode
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return e.Code == t.Code
+}
+
 // WithCause adds a cause to the error
 func (e *Error) WithCause(cause error) *Error {
 	newErr := *e
@@ -121,6 +140,13 @@ func (e *Error) WithStatusCode(statusCode int) *Error {
 	return &newErr
 }
 
+// WithRetryAfter adds the delay the server asked to wait before retrying
+func (e *Error) WithRetryAfter(retryAfter time.Duration) *Error {
+	newErr := *e
+	newErr.RetryAfter = retryAfter
+	return &newErr
+}
+
 // IsRetryable returns whether the error is retryable
 func (e *Error) IsRetryable() bool {
 	switch e.Type {
@@ -134,6 +160,22 @@ func (e *Error) IsRetryable() bool {
 	}
 }
 
+// isRetryableError reports whether err is an *Error that may succeed when attempted again.
+// Wrapping errors such as ErrRequestFailed are judged by their innermost *Error cause.
+func isRetryableError(err error) bool {
+	var langfuseErr *Error
+	if !errors.As(err, &langfuseErr) {
+		return false
+	}
+	for {
+		var cause *Error
+		if !errors.As(langfuseErr.Cause, &cause) {
+			return langfuseErr.IsRetryable()
+		}
+		langfuseErr = cause
+	}
+}
+
 // IsClientError returns whether the error is a client error (4xx)
 func (e *Error) IsClientError() bool {
 	return e.StatusCode >= 400 && e.StatusCode < 500
@@ -0,0 +1,143 @@
+package langfuse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xops-infra/GoLangfuse/logger"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// Exporter delivers batches of events to a sink, such as the Langfuse API, a file or stdout.
+// Implementations must be safe for concurrent use by multiple event processors.
+type Exporter interface {
+	// Export delivers the events and reports the outcome of each event.
+	// An error is only returned when the batch as a whole failed, rejected events are listed in the result.
+	// Retryable errors make the service retry the batch or the rejected events, like failed API requests.
+	Export(ctx context.Context, events []types.LangfuseEvent) (*BatchResult, error)
+}
+
+// ExporterFunc an adapter to use an ordinary function as an Exporter
+type ExporterFunc func(ctx context.Context, events []types.LangfuseEvent) (*BatchResult, error)
+
+// Export calls fn(ctx, events)
+func (fn ExporterFunc) Export(ctx context.Context, events []types.LangfuseEvent) (*BatchResult, error) {
+	return fn(ctx, events)
+}
+
+// httpExporter an Exporter sending events to the Langfuse ingestion API through a Client
+type httpExporter struct {
+	client Client
+}
+
+// NewHTTPExporter returns an Exporter sending events to the Langfuse ingestion API through the client,
+// the exporter the service uses unless another one is set with WithExporter
+func NewHTTPExporter(client Client) Exporter {
+	return httpExporter{client: client}
+}
+
+// Export sends the events in a single batch request
+func (e httpExporter) Export(ctx context.Context, events []types.LangfuseEvent) (*BatchResult, error) {
+	if client, ok := e.client.(BatchResultClient); ok {
+		return client.SendBatchWithResult(ctx, events)
+	}
+	// Without per event results the whole batch is either accepted or failed
+	if err := e.client.SendBatch(ctx, events); err != nil {
+		return nil, err
+	}
+	return &BatchResult{Sent: len(events), Failed: make(map[string]*Error)}, nil
+}
+
+// writerExporter an Exporter printing events as indented JSON, one block per event
+type writerExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewStdoutExporter returns an Exporter printing events to stdout in a readable form,
+// to see what would be sent while developing without a Langfuse server
+func NewStdoutExporter() Exporter {
+	return NewWriterExporter(os.Stdout)
+}
+
+// NewWriterExporter returns an Exporter printing events to w like NewStdoutExporter
+func NewWriterExporter(w io.Writer) Exporter {
+	return &writerExporter{w: w}
+}
+
+// Export prints the valid events, each one headed by its type, ID and timestamp
+func (e *writerExporter) Export(ctx context.Context, events []types.LangfuseEvent) (*BatchResult, error) {
+	result := &BatchResult{Failed: make(map[string]*Error)}
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetIndent("", "  ")
+	encodeEvents(ctx, events, result, func(id uuid.UUID, eventType string, timestamp time.Time, body types.LangfuseEvent) error {
+		mark := out.Len()
+		fmt.Fprintf(&out, "%s %s %s\n", timestamp.UTC().Format("2006-01-02T15:04:05.000Z"), eventType, id)
+		if err := encoder.Encode(body); err != nil {
+			out.Truncate(mark)
+			return err
+		}
+		result.Sent++
+		return nil
+	})
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.w.Write(out.Bytes()); err != nil {
+		return nil, ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "export_write"})
+	}
+	return result, nil
+}
+
+// fanOutExporter an Exporter writing every batch to a primary and secondary exporters
+type fanOutExporter struct {
+	primary     Exporter
+	secondaries []Exporter
+}
+
+// NewFanOutExporter returns an Exporter writing every batch to all the given exporters at once,
+// for example to the Langfuse API and to an audit file. The primary exporter decides the outcome:
+// its failures are retried or dead-lettered as usual, while failures of the secondary exporters
+// are only logged and do not affect delivery. Events retried for the primary are written to
+// the secondary exporters again.
+//
+// Example:
+//
+//	audit, _ := langfuse.NewFileExporter("/var/log/myapp/langfuse.jsonl", 100<<20, 10)
+//	exporter := langfuse.NewFanOutExporter(langfuse.NewHTTPExporter(langfuse.NewClient(cfg, httpClient)), audit)
+//	service, err := langfuse.New(cfg, langfuse.WithExporter(exporter))
+func NewFanOutExporter(primary Exporter, secondaries ...Exporter) Exporter {
+	return fanOutExporter{primary: primary, secondaries: secondaries}
+}
+
+// Export writes the events to every exporter concurrently and returns the outcome of the primary exporter
+func (e fanOutExporter) Export(ctx context.Context, events []types.LangfuseEvent) (*BatchResult, error) {
+	var wg sync.WaitGroup
+	for i, secondary := range e.secondaries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log := logger.FromContext(ctx)
+			result, err := secondary.Export(ctx, events)
+			switch {
+			case err != nil:
+				log.WithError(err).Warnf("secondary langfuse exporter %d failed to export %d events", i, len(events))
+			case len(result.Failed) > 0:
+				log.Warnf("secondary langfuse exporter %d rejected %d of %d events", i, len(result.Failed), len(events))
+			}
+		}()
+	}
+
+	result, err := e.primary.Export(ctx, events)
+	wg.Wait()
+	return result, err
+}
@@ -0,0 +1,132 @@
+package langfuse
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xops-infra/GoLangfuse/logger"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// defaultFileExporterMaxBytes is the size at which FileExporter rotates when none is configured
+const defaultFileExporterMaxBytes = 100 << 20
+
+// FileExporter an Exporter appending events as JSON lines to a file, in the ingestion envelope
+// format of the Langfuse API. The file is rotated once it reaches its size limit, rotated files
+// are renamed with the time of the rotation appended, e.g. events.jsonl.20250101T100000.000000000Z.
+type FileExporter struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileExporter opens or creates the JSONL file at path. The file is rotated when the next batch would grow it
+// beyond maxBytes, 100MB when zero, and only the newest maxBackups rotated files are kept, all of them when zero.
+func NewFileExporter(path string, maxBytes int64, maxBackups int) (*FileExporter, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultFileExporterMaxBytes
+	}
+
+	e := &FileExporter{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := e.open(); err != nil {
+		return nil, ErrInvalidConfig.WithCause(err).WithDetails(map[string]any{"export_file": path})
+	}
+	return e, nil
+}
+
+// Export appends the valid events to the file, rotating it first when it is full
+func (e *FileExporter) Export(ctx context.Context, events []types.LangfuseEvent) (*BatchResult, error) {
+	result := &BatchResult{Failed: make(map[string]*Error)}
+	body := newRequestBody()
+	defer body.release()
+	encodeEvents(ctx, events, result, body.addLine)
+	result.Sent = body.events
+	if body.events == 0 {
+		return result, nil
+	}
+	lines := body.buf.Bytes()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.size > 0 && e.size+int64(len(lines)) > e.maxBytes {
+		if err := e.rotate(ctx); err != nil {
+			return nil, ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "export_rotate", "export_file": e.path})
+		}
+	}
+
+	n, err := e.file.Write(lines)
+	e.size += int64(n)
+	if err != nil {
+		return nil, ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "export_write", "export_file": e.path})
+	}
+	return result, nil
+}
+
+// Close closes the current file
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
+
+// open opens the file for appending and reads its current size
+func (e *FileExporter) open() error {
+	file, err := os.OpenFile(e.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600) //nolint:gosec // path is provided by the user
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	e.file = file
+	e.size = info.Size()
+	return nil
+}
+
+// rotate renames the full file, opens a new one and removes the oldest rotated files beyond maxBackups.
+// The full file stays open until the new one is, so a failed rotation leaves the exporter appending to it.
+// Failing to remove old rotated files is only logged, the rotation itself succeeded. Must be called with mu locked.
+func (e *FileExporter) rotate(ctx context.Context) error {
+	rotatedPath := e.path + "." + time.Now().UTC().Format("20060102T150405.000000000Z")
+	if err := os.Rename(e.path, rotatedPath); err != nil {
+		return err
+	}
+	full := e.file
+	if err := e.open(); err != nil {
+		// Move the full file back so the next batch retries the rotation
+		_ = os.Rename(rotatedPath, e.path)
+		return err
+	}
+	// Everything was written to the full file already, a failure to close it loses nothing
+	_ = full.Close()
+
+	if e.maxBackups <= 0 {
+		return nil
+	}
+	log := logger.FromContext(ctx)
+	rotated, err := filepath.Glob(e.path + ".*Z")
+	if err != nil {
+		log.WithError(err).Warnf("failed to list rotated langfuse export files of %s", e.path)
+		return nil
+	}
+	// The timestamp suffix sorts rotated files oldest first
+	slices.Sort(rotated)
+	for _, path := range rotated[:max(0, len(rotated)-e.maxBackups)] {
+		if err := os.Remove(path); err != nil {
+			log.WithError(err).Warnf("failed to remove old langfuse export file %s", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package langfuse_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	langfuse "github.com/xops-infra/GoLangfuse"
+	"github.com/xops-infra/GoLangfuse/mock"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+func Test_WithExporter_ShouldFanOutEventsIgnoringSecondaryFailures(t *testing.T) {
+	transport := mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("unexpected request to %s", req.URL)
+		return nil, http.ErrHandlerTimeout
+	})
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	file, err := langfuse.NewFileExporter(path, 0, 0)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var stdout bytes.Buffer
+	failing := langfuse.ExporterFunc(func(context.Context, []types.LangfuseEvent) (*langfuse.BatchResult, error) {
+		return nil, langfuse.ErrConnectionFailed
+	})
+	exporter := langfuse.NewFanOutExporter(file, langfuse.NewWriterExporter(&stdout), failing)
+
+	subject := newTestService(t, newTestConfig(), transport, langfuse.WithExporter(exporter))
+
+	subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "local"})
+	subject.AddEvent(context.TODO(), &types.SpanEvent{Name: "step"})
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+	require.NoError(t, subject.Stop(context.TODO()))
+
+	assert.Equal(t, langfuse.FlushResult{EventsSent: 2}, result)
+	assert.Contains(t, stdout.String(), "trace-create")
+	assert.Contains(t, stdout.String(), `"name": "step"`)
+
+	content, err := os.Open(path)
+	require.NoError(t, err)
+	defer content.Close()
+	var eventTypes []string
+	scanner := bufio.NewScanner(content)
+	for scanner.Scan() {
+		var line struct {
+			Type string `json:"type"`
+		}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		eventTypes = append(eventTypes, line.Type)
+	}
+	assert.Equal(t, []string{"trace-create", "span-create"}, eventTypes)
+}
+
+func Test_FileExporter_ShouldRotateAndKeepMaxBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	exporter, err := langfuse.NewFileExporter(path, 200, 1)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	for range 4 {
+		id := uuid.New()
+		result, err := exporter.Export(context.TODO(), []types.LangfuseEvent{&types.TraceEvent{ID: &id, Name: "rotated"}})
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Sent)
+	}
+
+	files, err := filepath.Glob(path + "*")
+	require.NoError(t, err)
+	assert.Len(t, files, 2, "the current file and one rotated file")
+}
+
+// batchClient a Client only implementing the batch send, like clients written before per event results
+type batchClient struct {
+	err error
+}
+
+func (c batchClient) Send(context.Context, types.LangfuseEvent) error {
+	return c.err
+}
+
+func (c batchClient) SendBatch(context.Context, []types.LangfuseEvent) error {
+	return c.err
+}
+
+func Test_HTTPExporter_WithoutBatchResultClient_ShouldReportTheBatchAsAWhole(t *testing.T) {
+	events := []types.LangfuseEvent{&types.TraceEvent{Name: "first"}, &types.TraceEvent{Name: "second"}}
+
+	result, err := langfuse.NewHTTPExporter(batchClient{}).Export(context.TODO(), events)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Sent)
+	assert.Empty(t, result.Failed)
+
+	_, err = langfuse.NewHTTPExporter(batchClient{err: langfuse.ErrConnectionFailed}).Export(context.TODO(), events)
+	assert.ErrorIs(t, err, langfuse.ErrConnectionFailed)
+}
+
+func Test_FileExporter_WhenRotationFails_ShouldKeepExporting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	exporter, err := langfuse.NewFileExporter(path, 200, 0)
+	require.NoError(t, err)
+	defer exporter.Close()
+	export := func() error {
+		id := uuid.New()
+		_, err := exporter.Export(context.TODO(), []types.LangfuseEvent{&types.TraceEvent{ID: &id, Name: "rotated"}})
+		return err
+	}
+	require.NoError(t, export())
+
+	// The file being moved away by another process makes the rename of the rotation fail
+	require.NoError(t, os.Rename(path, path+".moved"))
+	require.ErrorIs(t, export(), langfuse.ErrEventProcessing)
+
+	// Once it is back the next batch rotates it and is written to the new file
+	require.NoError(t, os.Rename(path+".moved", path))
+	require.NoError(t, export())
+
+	files, err := filepath.Glob(path + "*")
+	require.NoError(t, err)
+	assert.Len(t, files, 2, "the current file and one rotated file")
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), `"name":"rotated"`))
+}
+
+func Test_FileExporter_WhenRemovingOldBackupsFails_ShouldKeepExporting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	exporter, err := langfuse.NewFileExporter(path, 200, 1)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	// A non-empty directory named like the oldest backup cannot be removed
+	oldest := path + ".20000101T000000.000000000Z"
+	require.NoError(t, os.Mkdir(oldest, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(oldest, "keep"), nil, 0o600))
+
+	for range 3 {
+		id := uuid.New()
+		result, err := exporter.Export(context.TODO(), []types.LangfuseEvent{&types.TraceEvent{ID: &id, Name: "rotated"}})
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Sent)
+	}
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), `"name":"rotated"`), "the last batch is written to the new file")
+	assert.DirExists(t, oldest)
+}
@@ -0,0 +1,77 @@
+package langfuse_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	langfuse "github.com/xops-infra/GoLangfuse"
+	"github.com/xops-infra/GoLangfuse/config"
+	"github.com/xops-infra/GoLangfuse/mock"
+)
+
+// newTestConfig returns a valid config with a single processor whose batches are only sent
+// on Flush or Stop, tests set the fields they exercise on top of it
+func newTestConfig() *config.Langfuse {
+	return &config.Langfuse{
+		URL:                    "http://localhost:3000",
+		PublicKey:              "LangfusePublicKey",
+		SecretKey:              "LangfuseSecretKey",
+		NumberOfEventProcessor: 1,
+		BatchSize:              10,
+		BatchTimeout:           time.Hour,
+	}
+}
+
+// newTestService creates a service sending its requests through the transport, stopped once the test ends
+func newTestService(t *testing.T, cfg *config.Langfuse, transport http.RoundTripper, opts ...langfuse.Option) langfuse.Langfuse {
+	t.Helper()
+	subject, err := langfuse.NewWithClient(cfg, &http.Client{Transport: transport}, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = subject.Stop(context.Background())
+	})
+	return subject
+}
+
+// newResponse returns a response with the status code and body
+func newResponse(statusCode int, body string) *http.Response {
+	return &http.Response{StatusCode: statusCode, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+// statusTransport answers every request with the status code and an empty JSON object
+func statusTransport(statusCode int) http.RoundTripper {
+	return mock.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return newResponse(statusCode, "{}"), nil
+	})
+}
+
+// requestRecorder a transport accepting every request and recording its body
+type requestRecorder struct {
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (r *requestRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.bodies = append(r.bodies, string(body))
+	r.mu.Unlock()
+	return newResponse(http.StatusOK, "{}"), nil
+}
+
+// requests returns the bodies recorded so far in the order they were sent
+func (r *requestRecorder) requests() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.bodies...)
+}
@@ -0,0 +1,73 @@
+package langfuse
+
+import (
+	"context"
+	"errors"
+
+	"github.com/xops-infra/GoLangfuse/logger"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// Interceptor modifies, replaces or drops an event before it is queued. It returns the event to queue,
+// either the given event or a replacement, or the error returned by Drop to leave the event out.
+// Any other error rejects the event and is returned by SubmitEvent.
+//
+// Example:
+//
+//	addRelease := func(_ context.Context, event types.LangfuseEvent) (types.LangfuseEvent, error) {
+//		if trace, ok := event.(*types.TraceEvent); ok && trace.Release == "" {
+//			trace.Release = os.Getenv("RELEASE")
+//		}
+//		return event, nil
+//	}
+type Interceptor func(ctx context.Context, event types.LangfuseEvent) (types.LangfuseEvent, error)
+
+// namedInterceptor an interceptor with the name its drops are counted under
+type namedInterceptor struct {
+	name        string
+	interceptor Interceptor
+}
+
+// Drop returns the error an Interceptor returns to drop the event for the given reason
+func Drop(reason string) error {
+	return ErrEventDropped.WithDetails(map[string]any{"reason": reason})
+}
+
+// DropSpansNamed returns an Interceptor dropping the spans with one of the given names, such as noisy health checks
+func DropSpansNamed(names ...string) Interceptor {
+	drop := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		drop[name] = struct{}{}
+	}
+	return func(_ context.Context, event types.LangfuseEvent) (types.LangfuseEvent, error) {
+		if span, ok := event.(*types.SpanEvent); ok {
+			if _, found := drop[span.Name]; found {
+				return nil, Drop("span " + span.Name + " is filtered")
+			}
+		}
+		return event, nil
+	}
+}
+
+// intercept runs the event through the interceptors in order. It returns the event to queue,
+// nil when an interceptor dropped it, or the error of an interceptor that rejected it.
+func (l *langfuseService) intercept(ctx context.Context, event types.LangfuseEvent) (types.LangfuseEvent, error) {
+	for _, named := range l.interceptors {
+		intercepted, err := named.interceptor(ctx, event)
+		switch {
+		case errors.Is(err, ErrEventDropped):
+			logger.FromContext(ctx).WithError(err).Debugf("langfuse event %s dropped by interceptor %s", event.GetID(), named.name)
+			l.metricsCollector.IncrementInterceptorDrops(named.name)
+			return nil, nil
+		case err != nil:
+			return nil, ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"interceptor": named.name})
+		case intercepted == nil:
+			return nil, ErrEventProcessing.WithDetails(map[string]any{"interceptor": named.name, "reason": "no event returned"})
+		}
+
+		// Replacements may come without an ID
+		ensureEventID(intercepted)
+		event = intercepted
+	}
+	return event, nil
+}
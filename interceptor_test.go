@@ -0,0 +1,61 @@
+package langfuse_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	langfuse "github.com/xops-infra/GoLangfuse"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+func Test_WithInterceptor_ShouldModifyReplaceAndDropEvents(t *testing.T) {
+	recorder := &requestRecorder{}
+
+	addRelease := func(_ context.Context, event types.LangfuseEvent) (types.LangfuseEvent, error) {
+		if trace, ok := event.(*types.TraceEvent); ok {
+			trace.Release = "v1.2.3"
+		}
+		return event, nil
+	}
+	renameLegacy := func(_ context.Context, event types.LangfuseEvent) (types.LangfuseEvent, error) {
+		if span, ok := event.(*types.SpanEvent); ok && span.Name == "legacy" {
+			return &types.SpanEvent{Name: "renamed"}, nil
+		}
+		return event, nil
+	}
+	rejectScores := func(_ context.Context, event types.LangfuseEvent) (types.LangfuseEvent, error) {
+		if _, ok := event.(*types.ScoreEvent); ok {
+			return nil, errors.New("scores are not allowed")
+		}
+		return event, nil
+	}
+	subject := newTestService(t, newTestConfig(), recorder,
+		langfuse.WithInterceptor("release", addRelease),
+		langfuse.WithInterceptor("noisy-spans", langfuse.DropSpansNamed("cache-lookup", "health-check")),
+		langfuse.WithInterceptor("rename", renameLegacy),
+		langfuse.WithInterceptor("no-scores", rejectScores),
+	)
+
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "checkout"}))
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.SpanEvent{Name: "cache-lookup"}))
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.SpanEvent{Name: "health-check"}))
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.SpanEvent{Name: "legacy"}))
+	_, err := subject.SubmitEvent(context.TODO(), &types.ScoreEvent{Name: "quality", Value: 1})
+	require.ErrorIs(t, err, langfuse.ErrEventProcessing)
+
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+
+	assert.Equal(t, langfuse.FlushResult{EventsSent: 2}, result)
+	assert.Equal(t, map[string]int64{"noisy-spans": 2}, subject.GetMetrics().EventsDroppedByInterceptor)
+	requests := recorder.requests()
+	require.Len(t, requests, 1)
+	assert.Contains(t, requests[0], `"release":"v1.2.3"`)
+	assert.Contains(t, requests[0], `"name":"renamed"`)
+	assert.NotContains(t, requests[0], `"name":"legacy"`)
+	assert.NotContains(t, requests[0], `"name":"cache-lookup"`)
+}
@@ -3,8 +3,11 @@ package langfuse
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,15 +18,18 @@ import (
 	"github.com/xops-infra/GoLangfuse/types"
 )
 
-// maxParallelItem is the maximum number of items that can be processed in parallel.
-const maxParallelItem = 512
-
 // Langfuse an interface to send ingestion events to langfuse in async manner
 // Event is added to the queue and then processor is sending it to the langfuse
 type Langfuse interface {
 	// AddEvent adds event to the channel and returns the event unique ID, generating one if missing.
+	// Returns nil when the event was not accepted, see SubmitEvent for the reason.
 	AddEvent(ctx context.Context, event types.LangfuseEvent) *uuid.UUID
-	// Stop gracefully shuts down the service and flushes remaining events
+	// SubmitEvent adds event to the channel like AddEvent but reports why the event was not accepted.
+	// Returns ErrServiceStopped after Stop was called and ErrQueueFull when dropped by the overflow policy.
+	// Events of traces that are not sampled or dropped by an interceptor are accepted without being sent.
+	SubmitEvent(ctx context.Context, event types.LangfuseEvent) (*uuid.UUID, error)
+	// Stop gracefully shuts down the service and flushes remaining events.
+	// It is safe to call Stop more than once and concurrently with AddEvent.
 	Stop(ctx context.Context) error
 	// GetMetrics returns current performance metrics
 	GetMetrics() Metrics
@@ -31,24 +37,74 @@ type Langfuse interface {
 	GetHealthStatus() HealthStatus
 	// CheckHealth performs health checks and returns status
 	CheckHealth(ctx context.Context) HealthStatus
+	// Flush sends all pending and queued events without stopping the service
+	// and reports how many events were sent or failed.
+	// Events held back by an open circuit breaker are not sent and not counted.
+	Flush(ctx context.Context) (FlushResult, error)
+}
+
+// FlushResult outcome of a Flush call
+type FlushResult struct {
+	EventsSent   int // EventsSent number of events delivered to langfuse
+	EventsFailed int // EventsFailed number of events that could not be delivered
+}
+
+// add accumulates another result into this one
+func (r *FlushResult) add(other FlushResult) {
+	r.EventsSent += other.EventsSent
+	r.EventsFailed += other.EventsFailed
 }
 
 type eventChanItem struct {
-	ctx   context.Context
-	event types.LangfuseEvent
+	ctx      context.Context // ctx detached from the caller, see newItem
+	event    types.LangfuseEvent
+	spooled  bool      // spooled the event is persisted in the spool and must be acknowledged once handled
+	size     int       // size estimated serialized size of the event, zero when batch size limits are disabled
+	priority Priority  // priority the queue lane of the event
+	deadline time.Time // deadline the event has to be delivered by, zero when it does not expire
+
+	destination string // destination the name of the project the event is sent to
+	seq         uint64 // seq the order the item was queued in, set by the queue
+
+	coalesced []eventChanItem // coalesced items merged into this one, acknowledged along with it
+}
+
+// flushRequest asks a processor to send everything it holds and report the outcome
+type flushRequest struct {
+	result chan<- FlushResult
 }
 
 type langfuseService struct {
-	client           Client
 	config           *config.Langfuse
-	eventChannel     chan eventChanItem
-	stopChannel      chan struct{}
+	destinations     map[string]*destination // destinations the default project and the projects events may be routed to
+	exporter         Exporter                // exporter replacing the Langfuse API for the default project, nil if not set
+	routes           []Destination
+	router           Router
+	queues           []*eventQueue // queues one shared by all processors, or one per processor when partitioned by trace
+	buffer           *syncBuffer   // buffer the events waiting for Flush in sync mode, nil when processed in the background
+	spool            *spool
+	deadLetterSink   DeadLetterSink
+	retries          *retryBudget
+	batchSizer       *batchSizer
+	classifier       PriorityClassifier
+	interceptors     []namedInterceptor
+	redactor         *redactor
+	truncator        *truncator
+	truncationLimits map[string]TruncationLimits // truncationLimits limits per event type set by WithTruncationLimits
+	sampler          *sampler
+	samplingRules    []SamplingRule
+	contextKeys      []any       // contextKeys context values propagated to event delivery besides the logger fields
+	closers          []io.Closer // closers resources owned by the service, closed once stopped
+	state            atomic.Int32
+	stopOnce         sync.Once
+	done             chan struct{}
+	flushRequests    []chan flushRequest
 	wg               sync.WaitGroup
 	metricsCollector *MetricsCollector
 }
 
 // New initialise new Langfuse instance for given config with background event processors
-func New(config *config.Langfuse) (Langfuse, error) {
+func New(config *config.Langfuse, opts ...Option) (Langfuse, error) {
 	if err := config.Validate(); err != nil {
 		logger := logger.FromContext(context.Background())
 		logger.Errorf("invalid langfuse configuration: %v", err)
@@ -56,11 +112,11 @@ func New(config *config.Langfuse) (Langfuse, error) {
 	}
 
 	optimizedClient := NewOptimizedHTTPClient(config)
-	return NewWithClient(config, optimizedClient)
+	return NewWithClient(config, optimizedClient, opts...)
 }
 
 // NewWithClient initialise new Langfuse instance with background event processors
-func NewWithClient(config *config.Langfuse, customHTTPClient *http.Client) (Langfuse, error) {
+func NewWithClient(config *config.Langfuse, customHTTPClient *http.Client, opts ...Option) (Langfuse, error) {
 	if err := config.Validate(); err != nil {
 		logger := logger.FromContext(context.Background())
 		logger.Errorf("invalid langfuse configuration: %v", err)
@@ -68,30 +124,174 @@ func NewWithClient(config *config.Langfuse, customHTTPClient *http.Client) (Lang
 	}
 
 	metricsCollector := NewMetricsCollector()
+	retries := newRetryBudget(config.RetryBudgetRatio)
 
 	eventManager := &langfuseService{
-		client:           NewClient(config, customHTTPClient),
+		classifier:       DefaultPriority,
+		router:           RouteByContext,
+		retries:          retries,
+		batchSizer:       newBatchSizer(config),
 		config:           config,
-		eventChannel:     make(chan eventChanItem, maxParallelItem),
-		stopChannel:      make(chan struct{}),
+		done:             make(chan struct{}),
 		metricsCollector: metricsCollector,
 	}
+	if config.SyncMode {
+		eventManager.buffer = newSyncBuffer(config)
+	} else {
+		eventManager.queues = newEventQueues(config)
+	}
+	eventManager.setState(stateStarting)
 
-	// Initialize metrics
-	metricsCollector.UpdateQueueMetrics(0, maxParallelItem)
-	metricsCollector.UpdateActiveProcessors(config.NumberOfEventProcessor)
+	for _, opt := range opts {
+		opt(eventManager)
+	}
+
+	eventManager.sampler = newSampler(config.SampleRate, eventManager.samplingRules, config.SampleKeepErrors)
+	eventManager.truncator = newTruncator(TruncationLimits{
+		MaxStringBytes: config.MaxStringBytes,
+		MaxArrayItems:  config.MaxArrayItems,
+	}, eventManager.truncationLimits)
+
+	destinations, err := newDestinations(config, customHTTPClient, eventManager.exporter, eventManager.routes, retries, metricsCollector)
+	if err != nil {
+		logger.FromContext(context.Background()).WithError(err).Error("invalid langfuse destination")
+		return nil, err
+	}
+	eventManager.destinations = destinations
 
-	eventManager.startBatchProcessors(config.NumberOfEventProcessor)
+	if eventManager.deadLetterSink == nil && config.DeadLetterFile != "" {
+		sink, err := NewFileDeadLetterSink(config.DeadLetterFile)
+		if err != nil {
+			logger.FromContext(context.Background()).WithError(err).Errorf("failed to open langfuse dead letter file %s", config.DeadLetterFile)
+			return nil, err
+		}
+		eventManager.deadLetterSink = sink
+		eventManager.closers = append(eventManager.closers, sink)
+	}
+
+	var replay spoolReplay
+	if config.SpoolDir != "" {
+		eventManager.spool, replay, err = openSpool(config.SpoolDir, config.SpoolMaxBytes, config.SpoolSegmentBytes)
+		if err != nil {
+			logger.FromContext(context.Background()).WithError(err).Errorf("failed to open langfuse spool in %s", config.SpoolDir)
+			for _, closer := range eventManager.closers {
+				_ = closer.Close()
+			}
+			return nil, err
+		}
+		metricsCollector.RecordSpoolReplay(len(replay.events), replay.corrupt)
+		metricsCollector.UpdateSpoolSize(eventManager.spool.size())
+	}
+
+	// Initialize metrics
+	eventManager.updateQueueMetrics()
+	metricsCollector.UpdateBatchSize(eventManager.batchSizer.current(config.BatchSize))
+	metricsCollector.UpdateSyncMode(config.SyncMode)
+	if !config.SyncMode {
+		metricsCollector.UpdateActiveProcessors(config.NumberOfEventProcessor)
+		eventManager.startBatchProcessors(config.NumberOfEventProcessor)
+	}
+	eventManager.setState(stateRunning)
+	eventManager.startSpoolReplay(replay.events)
 	return eventManager, nil
 }
 
 // AddEvent adds event to the channel and returns the event unique ID, generating one if missing.
+// Returns nil when the event was not accepted, see SubmitEvent for the reason.
 func (l *langfuseService) AddEvent(ctx context.Context, event types.LangfuseEvent) *uuid.UUID {
+	id, err := l.SubmitEvent(ctx, event)
+	if err != nil {
+		log := logger.FromContext(ctx).WithError(err)
+		// Events rejected before an ID was generated are identified by their type
+		if eventID := event.GetID(); eventID != nil {
+			log.Warnf("langfuse %s event %s was not accepted", getEventType(event), eventID)
+		} else {
+			log.Warnf("langfuse %s event was not accepted", getEventType(event))
+		}
+		return nil
+	}
+	return id
+}
+
+// SubmitEvent adds event to the channel like AddEvent but reports why the event was not accepted.
+// Returns ErrServiceStopped after Stop was called and ErrQueueFull when dropped by the overflow policy.
+func (l *langfuseService) SubmitEvent(ctx context.Context, event types.LangfuseEvent) (*uuid.UUID, error) {
+	if l.currentState() != stateRunning {
+		return nil, ErrServiceStopped.WithDetails(map[string]any{"state": l.currentState().String()})
+	}
+
 	ensureEventID(event)
-	l.eventChannel <- eventChanItem{ctx: ctx, event: event}
+	id := event.GetID()
+
+	// Events dropped by an interceptor are accepted but not sent
+	event, err := l.intercept(ctx, event)
+	if err != nil {
+		l.metricsCollector.IncrementEventsFailed(err)
+		return nil, err
+	}
+	if event == nil {
+		return id, nil
+	}
+
+	// Sampled out events are accepted but not sent
+	if !l.sampler.keep(event) {
+		l.metricsCollector.IncrementEventsSampledOut()
+		return event.GetID(), nil
+	}
+
+	destination, err := l.route(ctx, event)
+	if err != nil {
+		l.metricsCollector.IncrementEventsFailed(err)
+		return nil, err
+	}
+
+	// The payload is rewritten on a copy, the caller's Input, Output and Metadata are left untouched
+	event = l.redact(event)
+	event = l.truncate(event)
+
+	item := l.newItem(ctx, event)
+	item.destination = destination
+	if err := l.measure(&item); err != nil {
+		l.metricsCollector.IncrementEventsFailed(err)
+		return nil, err
+	}
+	if l.config.MaxBatchBytes > 0 && item.size+batchEnvelopeOverhead > l.config.MaxBatchBytes {
+		err := ErrEventTooLarge.WithDetails(map[string]any{
+			"event_id":        event.GetID().String(),
+			"size":            item.size,
+			"max_batch_bytes": l.config.MaxBatchBytes,
+		})
+		l.metricsCollector.IncrementEventsFailed(err)
+		return nil, err
+	}
+	item.spooled = l.persist(ctx, event, destination)
+
+	if l.buffer != nil {
+		if err := l.bufferEvent(ctx, item); err != nil {
+			l.acknowledge(item)
+			return nil, err
+		}
+		return event.GetID(), nil
+	}
+
+	evicted, err := l.queueFor(event).put(ctx, item)
+	for _, dropped := range evicted {
+		logger.FromContext(dropped.ctx).Warnf("langfuse queue is full, dropped %s priority event %s", dropped.priority, dropped.event.GetID())
+		l.metricsCollector.IncrementEventsDropped()
+		l.acknowledge(dropped)
+	}
+	if err != nil {
+		if errors.Is(err, ErrQueueFull) {
+			l.metricsCollector.IncrementEventsDropped()
+		}
+		l.acknowledge(item)
+		return nil, err
+	}
+
 	l.metricsCollector.IncrementEventsQueued()
-	l.metricsCollector.UpdateQueueMetrics(len(l.eventChannel), maxParallelItem)
-	return event.GetID()
+	l.metricsCollector.IncrementDestinationEventsQueued(destination)
+	l.updateQueueMetrics()
+	return event.GetID(), nil
 }
 
 // startBatchProcessors start the background batch processors
@@ -101,7 +301,9 @@ func (l *langfuseService) startBatchProcessors(count int) {
 		return
 	}
 
+	l.flushRequests = make([]chan flushRequest, count)
 	for i := range count {
+		l.flushRequests[i] = make(chan flushRequest)
 		l.wg.Add(1)
 		go func(processorID int) {
 			defer l.wg.Done()
@@ -115,118 +317,368 @@ func (l *langfuseService) processBatches(processorID int) {
 	log := logger.FromContext(context.Background())
 	log.Debugf("Starting batch processor %d", processorID)
 
-	var batch []eventChanItem
+	queue := l.processorQueue(processorID)
+	batch := newPendingBatch(queue.capacity())
 	ticker := time.NewTicker(l.config.BatchTimeout)
 	defer ticker.Stop()
 
-	flushBatch := func() {
-		if len(batch) == 0 {
-			return
-		}
-
-		// Group events by context (for better tracing)
-		contextGroups := make(map[context.Context][]types.LangfuseEvent)
-		for _, item := range batch {
-			contextGroups[item.ctx] = append(contextGroups[item.ctx], item.event)
-		}
+	// Processors send independently of any caller
+	ctx := context.Background()
 
-		// Send each context group as a batch
-		for ctx, events := range contextGroups {
-			l.sendBatch(ctx, events)
-		}
-
-		batch = batch[:0] // Clear the batch
+	// stop flushes what is left before the processor exits, events still held back are given up on
+	stop := func() {
+		l.abandon(ctx, batch)
+		log.Debugf("Batch processor %d stopped", processorID)
 	}
 
+	draining := false
 	for {
+		// While the circuit breaker is open events are left in the queue, so the overflow policy
+		// applies to new events, until the cool-down elapsed or the service is stopped
+		ready := queue.ready
+		var closing <-chan struct{}
+		if !draining && l.holding() {
+			ready = nil
+			closing = queue.closing
+		}
+
 		select {
-		case item, ok := <-l.eventChannel:
+		case _, ok := <-ready:
 			if !ok {
-				// Channel closed, flush remaining events and exit
-				flushBatch()
-				log.Debugf("Batch processor %d stopped", processorID)
+				// Queue closed, flush remaining events and exit
+				stop()
 				return
 			}
-
-			batch = append(batch, item)
-
-			// Update queue metrics
-			l.metricsCollector.UpdateQueueMetrics(len(l.eventChannel), maxParallelItem)
-
-			// Flush batch if it reaches the configured size
-			if len(batch) >= l.config.BatchSize {
-				flushBatch()
+			if item, ok := queue.take(); ok {
+				l.add(ctx, batch, item)
 			}
 
+		case <-closing:
+			draining = true
+
 		case <-ticker.C:
 			// Flush batch on timeout
-			flushBatch()
+			l.flush(ctx, batch)
+
+		case request := <-l.flushRequests[processorID]:
+			// Send everything queued at this moment together with the pending batch
+			var result FlushResult
+			closed := false
+		drain:
+			for !l.holding() {
+				select {
+				case _, ok := <-queue.ready:
+					if !ok {
+						closed = true
+						break drain
+					}
+					if item, ok := queue.take(); ok {
+						result.add(l.add(ctx, batch, item))
+					}
+				default:
+					break drain
+				}
+			}
+			result.add(l.flush(ctx, batch))
+			request.result <- result
 
-		case <-l.stopChannel:
-			// Graceful shutdown requested
-			flushBatch()
-			log.Debugf("Batch processor %d stopped gracefully", processorID)
-			return
+			if closed {
+				stop()
+				return
+			}
 		}
 	}
 }
 
-// sendBatch sends a batch of events to Langfuse and logs any issues
-func (l *langfuseService) sendBatch(ctx context.Context, events []types.LangfuseEvent) {
+// sendBatch sends a batch of events to the destination, logs any issues and reports how many events were sent or failed.
+// Expired events are failed without being sent, events not attempted because the circuit breaker is open
+// are returned so they can be held back.
+func (l *langfuseService) sendBatch(bound context.Context, d *destination, items []eventChanItem) (FlushResult, []eventChanItem) {
+	var result FlushResult
+	items = l.expire(items, &result)
+	if len(items) == 0 {
+		return result, nil
+	}
+
+	ctx, cancel := deliveryContext(bound, items)
+	defer cancel()
+
 	log := logger.FromContext(ctx)
-	log.Debugf("sending batch of %d events to langfuse", len(events))
+	log.Debugf("sending batch of %d events to langfuse destination %s", len(items), d.name)
 
 	startTime := time.Now()
-	err := l.client.SendBatch(ctx, events)
+	batchResult, err := d.exporter.Export(ctx, batchEvents(items))
 	responseTime := time.Since(startTime)
 
+	if errors.Is(err, ErrCircuitOpen) {
+		return result, items
+	}
+	if l.batchSizer != nil {
+		// Time spent waiting for the rate limit or between retries says nothing about the batch size
+		latency := responseTime
+		if batchResult != nil && batchResult.Latency > 0 {
+			latency = batchResult.Latency
+		}
+		l.metricsCollector.UpdateBatchSize(l.batchSizer.observe(latency, err))
+	}
+
 	if err != nil {
-		log.WithError(err).Errorf("failed to send batch of %d events", len(events))
+		log.WithError(err).Errorf("failed to send batch of %d events", len(items))
 		l.metricsCollector.IncrementBatchesFailed(err)
 		l.metricsCollector.RecordHTTPRequest(false, responseTime)
 
-		// Fall back to individual sends on batch failure
-		for _, event := range events {
+		// A server-wide failure (connection refused, 5xx, 429) would fail every individual send as well
+		if isRetryableError(err) {
+			for _, item := range items {
+				l.failEvent(item, err)
+			}
+			result.EventsFailed = len(items)
+			return result, nil
+		}
+
+		// Fall back to individual sends to isolate the events the batch was rejected for
+		for i, item := range items {
 			individualStart := time.Now()
-			if sendErr := l.client.Send(ctx, event); sendErr != nil {
-				log.WithError(sendErr).Errorf("failed to send individual event %v", event)
-				l.metricsCollector.IncrementEventsFailed(sendErr)
+			individualResult, sendErr := d.exporter.Export(ctx, []types.LangfuseEvent{item.event})
+			if eventErr := individualResult.Err(item.event.GetID().String()); sendErr == nil && eventErr != nil {
+				sendErr = eventErr
+			}
+			switch {
+			case errors.Is(sendErr, ErrCircuitOpen):
+				// The failures so far opened the breaker, hold the rest back
+				return result, items[i:]
+			case sendErr != nil:
 				l.metricsCollector.RecordHTTPRequest(false, time.Since(individualStart))
-			} else {
-				l.metricsCollector.IncrementEventsProcessed()
+				l.failEvent(item, sendErr)
+				result.EventsFailed++
+			default:
 				l.metricsCollector.RecordHTTPRequest(true, time.Since(individualStart))
+				l.succeedEvent(item)
+				result.EventsSent++
+			}
+		}
+		return result, nil
+	}
+
+	l.metricsCollector.IncrementBatchesProcessed()
+	l.metricsCollector.RecordHTTPRequest(true, responseTime)
+
+	// Only the events rejected with a retryable error are sent again, accepted ones are never duplicated
+	delays := newBackoff(l.config)
+	for attempt := 1; ; attempt++ {
+		var retry []eventChanItem
+		for _, item := range items {
+			eventErr := batchResult.Err(item.event.GetID().String())
+			switch {
+			case eventErr == nil:
+				l.succeedEvent(item)
+				result.EventsSent++
+			case eventErr.IsRetryable() && attempt <= l.config.MaxRetries:
+				retry = append(retry, item)
+			default:
+				l.failEvent(item, eventErr)
+				result.EventsFailed++
+			}
+		}
+		if len(retry) == 0 {
+			return result, nil
+		}
+
+		if !l.retries.withdraw() {
+			log.Warnf("retry budget exhausted, not retrying %d events rejected by langfuse", len(retry))
+			l.metricsCollector.IncrementRetriesDenied()
+			for _, item := range retry {
+				l.failEvent(item, batchResult.Err(item.event.GetID().String()))
+				result.EventsFailed++
+			}
+			return result, nil
+		}
+
+		log.Warnf("retrying %d of %d events rejected by langfuse", len(retry), len(items))
+		select {
+		case <-time.After(delays.next(attempt, nil)):
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		if err == nil {
+			startTime = time.Now()
+			batchResult, err = d.exporter.Export(ctx, batchEvents(retry))
+			if errors.Is(err, ErrCircuitOpen) {
+				return result, retry
 			}
+			l.metricsCollector.RecordHTTPRequest(err == nil, time.Since(startTime))
+		}
+		if err != nil {
+			for _, item := range retry {
+				l.failEvent(item, err)
+				result.EventsFailed++
+			}
+			return result, nil
+		}
+		items = retry
+	}
+}
+
+// newItem prepares the event for the queue. The item keeps a context detached from the caller's,
+// carrying the logger fields and propagated values, so cancelling the caller does not cancel delivery.
+func (l *langfuseService) newItem(ctx context.Context, event types.LangfuseEvent) eventChanItem {
+	detached := logger.Detach(ctx)
+	for _, key := range l.contextKeys {
+		if value := ctx.Value(key); value != nil {
+			detached = context.WithValue(detached, key, value)
+		}
+	}
+
+	item := eventChanItem{ctx: detached, event: event, priority: l.classify(event)}
+	if l.config.DeliveryTimeout > 0 {
+		item.deadline = time.Now().Add(l.config.DeliveryTimeout)
+	}
+	return item
+}
+
+// expire fails the items past their delivery deadline and returns the others
+func (l *langfuseService) expire(items []eventChanItem, result *FlushResult) []eventChanItem {
+	now := time.Now()
+	live := items[:0:0]
+	for _, item := range items {
+		if item.deadline.IsZero() || now.Before(item.deadline) {
+			live = append(live, item)
+			continue
+		}
+		l.failEvent(item, ErrDeliveryTimeout.WithDetails(map[string]any{
+			"delivery_timeout": l.config.DeliveryTimeout.String(),
+		}))
+		result.EventsFailed++
+	}
+	return live
+}
+
+// deliveryContext returns the context a batch is sent with: the context of its first event,
+// bounded by the earliest delivery deadline of its events and cancelled along with bound
+func deliveryContext(bound context.Context, items []eventChanItem) (context.Context, context.CancelFunc) {
+	var deadline time.Time
+	for _, item := range items {
+		if !item.deadline.IsZero() && (deadline.IsZero() || item.deadline.Before(deadline)) {
+			deadline = item.deadline
 		}
+	}
+
+	ctx, cancel := items[0].ctx, context.CancelFunc(func() {})
+	if deadline.IsZero() && bound.Done() == nil {
+		return ctx, cancel
+	}
+
+	if deadline.IsZero() {
+		ctx, cancel = context.WithCancel(ctx)
 	} else {
-		l.metricsCollector.IncrementBatchesProcessed()
-		l.metricsCollector.RecordHTTPRequest(true, responseTime)
-		// Update processed events count
-		for range events {
-			l.metricsCollector.IncrementEventsProcessed()
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+	}
+	stop := context.AfterFunc(bound, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// succeedEvent records an event accepted by langfuse and removes it from the spool
+func (l *langfuseService) succeedEvent(item eventChanItem) {
+	l.metricsCollector.IncrementEventsProcessed()
+	l.acknowledge(item)
+}
+
+// failEvent records an event that could not be delivered and hands it to the dead letter sink.
+// Events failed by an outage stay in the spool so they are replayed on the next start,
+// unless the dead letter sink took them over.
+func (l *langfuseService) failEvent(item eventChanItem, err error) {
+	logger.FromContext(item.ctx).WithError(err).Errorf("failed to send event %s", item.event.GetID())
+	l.metricsCollector.IncrementEventsFailed(err)
+	if l.deadLetter(item, err) || !isRetryableError(err) {
+		l.acknowledge(item)
+	}
+}
+
+// batchEvents returns the events of the given items
+func batchEvents(items []eventChanItem) []types.LangfuseEvent {
+	events := make([]types.LangfuseEvent, len(items))
+	for i, item := range items {
+		events[i] = item.event
+	}
+	return events
+}
+
+// measure estimates the serialized size of the item's event when batch byte limits are enabled
+func (l *langfuseService) measure(item *eventChanItem) error {
+	if l.config.MaxBatchBytes <= 0 {
+		return nil
+	}
+
+	size, err := estimateEventSize(item.event)
+	if err != nil {
+		return ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "estimate_size"})
+	}
+	item.size = size
+	return nil
+}
+
+// Flush makes every processor send its pending batch and the events queued at the time of the call,
+// waits for the requests to complete and reports how many events were sent or failed.
+// In sync mode the buffered events are sent inline, cancelled along with ctx.
+// The service keeps running after Flush returns.
+func (l *langfuseService) Flush(ctx context.Context) (FlushResult, error) {
+	var result FlushResult
+	if l.currentState() != stateRunning {
+		return result, ErrServiceStopped.WithDetails(map[string]any{"state": l.currentState().String()})
+	}
+
+	if l.buffer != nil {
+		result = l.flushBuffer(ctx)
+		return result, ctx.Err()
+	}
+
+	// Buffered so processors never block on a caller that gave up waiting
+	results := make(chan FlushResult, len(l.flushRequests))
+	requested := 0
+	for _, requests := range l.flushRequests {
+		select {
+		case requests <- flushRequest{result: results}:
+			requested++
+		case <-l.done:
+			return result, ErrServiceStopped
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+	}
+
+	for range requested {
+		select {
+		case processorResult := <-results:
+			result.add(processorResult)
+		case <-l.done:
+			return result, ErrServiceStopped
+		case <-ctx.Done():
+			return result, ctx.Err()
 		}
 	}
+	return result, nil
 }
 
-// Stop gracefully shuts down the service and flushes remaining events
+// Stop gracefully shuts down the service and flushes remaining events.
+// It is safe to call Stop more than once and concurrently with AddEvent.
 func (l *langfuseService) Stop(ctx context.Context) error {
 	log := logger.FromContext(ctx)
 	log.Info("Stopping Langfuse service...")
 
-	// Signal all processors to stop
-	close(l.stopChannel)
-
-	// Close the event channel to signal no more events
-	close(l.eventChannel)
+	// Stop accepting events and let the processors drain the queue, or send the buffer inline in sync mode
+	if l.buffer != nil {
+		l.stopOnce.Do(func() { l.stopSync(ctx) })
+	} else {
+		l.stopOnce.Do(l.beginShutdown)
+	}
 
 	// Wait for all processors to finish with timeout
-	done := make(chan struct{})
-	go func() {
-		l.wg.Wait()
-		close(done)
-	}()
-
 	select {
-	case <-done:
+	case <-l.done:
 		log.Info("Langfuse service stopped gracefully")
 		return nil
 	case <-ctx.Done():
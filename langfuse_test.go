@@ -2,10 +2,14 @@ package langfuse_test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -48,3 +52,460 @@ func Test_AddEvent_ShouldCallClientToSendEvents(t *testing.T) {
 	assert.Contains(t, string(body), `"name":"LLM"`)
 	assert.Contains(t, string(body), `"public":false`)
 }
+
+func Test_AddEvent_WithDropNewestPolicy_ShouldDropEventWhenQueueIsFull(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BatchSize = 1
+	cfg.QueueCapacity = 1
+	cfg.OverflowPolicy = config.OverflowDropNewest
+
+	requestStarted := make(chan struct{}, 1)
+	release := make(chan struct{})
+	subject := newTestService(t, cfg, mock.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+		select {
+		case requestStarted <- struct{}{}:
+		default:
+		}
+		<-release
+		return newResponse(http.StatusOK, "{}"), nil
+	}))
+
+	// First event is picked up by the processor which then blocks on the HTTP call
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "first"}))
+	<-requestStarted
+
+	// Second event fills the queue, third one is dropped
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "second"}))
+	assert.Nil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "third"}))
+	assert.Equal(t, int64(1), subject.GetMetrics().EventsDropped)
+
+	close(release)
+	require.NoError(t, subject.Stop(context.TODO()))
+}
+
+func Test_SubmitEvent_AfterStop_ShouldReturnServiceStoppedError(t *testing.T) {
+	subject := newTestService(t, newTestConfig(), statusTransport(http.StatusOK))
+
+	require.NoError(t, subject.Stop(context.TODO()))
+	require.NoError(t, subject.Stop(context.TODO()), "Stop should be idempotent")
+
+	id, err := subject.SubmitEvent(context.TODO(), &types.TraceEvent{Name: "late"})
+	assert.Nil(t, id)
+	require.ErrorIs(t, err, langfuse.ErrServiceStopped)
+	assert.Nil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "late"}))
+}
+
+func Test_AddEvent_ConcurrentWithStop_ShouldNotPanic(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.NumberOfEventProcessor = 2
+	cfg.BatchSize = 5
+	cfg.BatchTimeout = 10 * time.Millisecond
+	cfg.QueueCapacity = 4
+	subject := newTestService(t, cfg, statusTransport(http.StatusOK))
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "concurrent"})
+			}
+		}()
+	}
+
+	require.NoError(t, subject.Stop(context.TODO()))
+	wg.Wait()
+}
+
+func Test_Flush_ShouldSendPendingEventsWithoutStopping(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.NumberOfEventProcessor = 2
+	cfg.BatchSize = 100
+	subject := newTestService(t, cfg, statusTransport(http.StatusOK))
+
+	for range 3 {
+		require.NotNil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "flush"}))
+	}
+
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, langfuse.FlushResult{EventsSent: 3}, result)
+	assert.Equal(t, int64(3), subject.GetMetrics().EventsProcessed)
+
+	// Service is still running after flush
+	_, err = subject.SubmitEvent(context.TODO(), &types.TraceEvent{Name: "after-flush"})
+	require.NoError(t, err)
+}
+
+func Test_SubmitEvent_WithMaxBatchBytes_ShouldSplitBatchesAndRejectOversizedEvents(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.MaxBatchBytes = 1000
+	recorder := &requestRecorder{}
+	subject := newTestService(t, cfg, recorder)
+
+	_, err := subject.SubmitEvent(context.TODO(), &types.TraceEvent{Name: "huge", Input: strings.Repeat("x", 2000)})
+	require.ErrorIs(t, err, langfuse.ErrEventTooLarge)
+
+	for range 3 {
+		_, err = subject.SubmitEvent(context.TODO(), &types.TraceEvent{Name: "medium", Input: strings.Repeat("x", 200)})
+		require.NoError(t, err)
+	}
+
+	_, err = subject.Flush(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), subject.GetMetrics().EventsProcessed)
+
+	requests := recorder.requests()
+	require.Len(t, requests, 2)
+	for _, body := range requests {
+		assert.LessOrEqual(t, len(body), cfg.MaxBatchBytes)
+	}
+}
+
+func Test_Flush_ShouldRetryOnlyRetryableRejectedEvents(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.MaxRetries = 1
+	acceptedID, retriedID, rejectedID := uuid.New(), uuid.New(), uuid.New()
+
+	var mu sync.Mutex
+	var requests []string
+	subject := newTestService(t, cfg, mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		mu.Lock()
+		requests = append(requests, string(body))
+		attempt := len(requests)
+		mu.Unlock()
+
+		response := `{"successes":[],"errors":[]}`
+		if attempt == 1 {
+			response = `{"successes":[{"id":"` + acceptedID.String() + `","status":201}],"errors":[` +
+				`{"id":"` + retriedID.String() + `","status":503,"message":"unavailable"},` +
+				`{"id":"` + rejectedID.String() + `","status":400,"message":"invalid"}]}`
+		}
+		return newResponse(http.StatusMultiStatus, response), nil
+	}))
+
+	for _, id := range []uuid.UUID{acceptedID, retriedID, rejectedID} {
+		subject.AddEvent(context.TODO(), &types.TraceEvent{ID: &id, Name: "example"})
+	}
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+	require.NoError(t, subject.Stop(context.TODO()))
+
+	assert.Equal(t, langfuse.FlushResult{EventsSent: 2, EventsFailed: 1}, result)
+	require.Len(t, requests, 2)
+	assert.Contains(t, requests[1], retriedID.String())
+	assert.NotContains(t, requests[1], acceptedID.String())
+	assert.NotContains(t, requests[1], rejectedID.String())
+
+	metrics := subject.GetMetrics()
+	assert.Equal(t, int64(2), metrics.EventsProcessed)
+	assert.Equal(t, int64(1), metrics.EventsFailed)
+}
+
+func Test_Flush_ShouldWaitForEventsPerSecondLimit(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BatchSize = 50
+	cfg.EventsPerSecond = 50
+	subject := newTestService(t, cfg, statusTransport(http.StatusOK))
+
+	for range 60 {
+		subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "example"})
+	}
+	_, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+
+	// The first batch uses up the bucket, the remaining 10 events wait for 200ms worth of tokens
+	metrics := subject.GetMetrics()
+	assert.Equal(t, int64(60), metrics.EventsProcessed)
+	assert.Equal(t, int64(1), metrics.RateLimitWaits)
+	assert.GreaterOrEqual(t, metrics.RateLimitWaitTime, 150*time.Millisecond)
+}
+
+func Test_Flush_ShouldNotFallBackToIndividualSendsOnServerErrors(t *testing.T) {
+	var requests atomic.Int32
+	subject := newTestService(t, newTestConfig(), mock.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+		requests.Add(1)
+		return newResponse(http.StatusBadGateway, "bad gateway"), nil
+	}))
+
+	for range 3 {
+		subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "example"})
+	}
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+
+	assert.Equal(t, langfuse.FlushResult{EventsFailed: 3}, result)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func Test_AdaptiveBatching_ShouldShrinkOnServerErrorsAndGrowOnSuccess(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BatchSize = 4
+	cfg.AdaptiveBatching = true
+	cfg.MinBatchSize = 1
+	cfg.MaxBatchSize = 8
+	cfg.BatchLatencyTarget = time.Second
+	var statusCode atomic.Int32
+	statusCode.Store(http.StatusInternalServerError)
+	subject := newTestService(t, cfg, mock.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return newResponse(int(statusCode.Load()), "{}"), nil
+	}))
+	assert.Equal(t, 4, subject.GetMetrics().CurrentBatchSize)
+
+	subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "example"})
+	_, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, 2, subject.GetMetrics().CurrentBatchSize)
+
+	statusCode.Store(http.StatusOK)
+	subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "example"})
+	_, err = subject.Flush(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, 3, subject.GetMetrics().CurrentBatchSize)
+}
+
+func Test_AdaptiveBatching_ShouldNotCountRateLimitWaitsAsLatency(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BatchSize = 4
+	cfg.AdaptiveBatching = true
+	cfg.MinBatchSize = 1
+	cfg.MaxBatchSize = 8
+	cfg.BatchLatencyTarget = 200 * time.Millisecond
+	cfg.RequestsPerSecond = 1
+	subject := newTestService(t, cfg, statusTransport(http.StatusOK))
+
+	// The second request waits about a second for the rate limit but is answered at once
+	for _, want := range []int{5, 6} {
+		subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "example"})
+		_, err := subject.Flush(context.TODO())
+		require.NoError(t, err)
+		assert.Equal(t, want, subject.GetMetrics().CurrentBatchSize)
+	}
+}
+
+func Test_AddEvent_WithDropNewestPolicy_ShouldShedLowPriorityEventsAndSendHighPriorityFirst(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BatchSize = 1
+	cfg.QueueCapacity = 2
+	cfg.OverflowPolicy = config.OverflowDropNewest
+
+	requestStarted := make(chan struct{}, 1)
+	release := make(chan struct{})
+	recorder := &requestRecorder{}
+	subject := newTestService(t, cfg, mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		response, err := recorder.RoundTrip(req)
+		select {
+		case requestStarted <- struct{}{}:
+		default:
+		}
+		<-release
+		return response, err
+	}))
+
+	// First event is picked up by the processor which then blocks on the HTTP call
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "first"}))
+	<-requestStarted
+
+	// Debug spans fill the queue, the error span takes the place of the newest one
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.SpanEvent{Name: "debug-1", Level: types.Debug}))
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.SpanEvent{Name: "debug-2", Level: types.Debug}))
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.SpanEvent{Name: "error", Level: types.Error}))
+	assert.Nil(t, subject.AddEvent(context.TODO(), &types.SpanEvent{Name: "debug-3", Level: types.Debug}))
+	assert.Equal(t, int64(2), subject.GetMetrics().EventsDropped)
+
+	close(release)
+	require.NoError(t, subject.Stop(context.TODO()))
+
+	sent := recorder.requests()
+	require.Len(t, sent, 3)
+	assert.Contains(t, sent[1], `"name":"error"`)
+	assert.Contains(t, sent[2], `"name":"debug-1"`)
+}
+
+func Test_Flush_ShouldBatchAcrossCancelledContexts(t *testing.T) {
+	var requests atomic.Int32
+	subject := newTestService(t, newTestConfig(), mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requests.Add(1)
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		return newResponse(http.StatusOK, "{}"), nil
+	}))
+
+	// Events of two requests whose contexts are cancelled before the flush
+	for range 2 {
+		ctx, cancel := context.WithCancel(context.Background())
+		require.NotNil(t, subject.AddEvent(ctx, &types.TraceEvent{Name: "example"}))
+		cancel()
+	}
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+
+	assert.Equal(t, langfuse.FlushResult{EventsSent: 2}, result)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func Test_Flush_ShouldFailEventsPastDeliveryTimeout(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.DeliveryTimeout = time.Millisecond
+	recorder := &requestRecorder{}
+	subject := newTestService(t, cfg, recorder)
+
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "example"}))
+	time.Sleep(10 * time.Millisecond)
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+
+	assert.Equal(t, langfuse.FlushResult{EventsFailed: 1}, result)
+	assert.Empty(t, recorder.requests())
+}
+
+func Test_PartitionByTrace_ShouldSendEventsOfATraceInOrder(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.NumberOfEventProcessor = 4
+	cfg.BatchSize = 1
+	cfg.PartitionByTrace = true
+	var mu sync.Mutex
+	sent := make(map[string][]int)
+	subject := newTestService(t, cfg, mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var request struct {
+			Batch []struct {
+				Body struct {
+					Name string `json:"name"`
+				} `json:"body"`
+			} `json:"batch"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		for _, event := range request.Batch {
+			var trace string
+			var step int
+			_, _ = fmt.Sscanf(event.Body.Name, "%s step %d", &trace, &step)
+			sent[trace] = append(sent[trace], step)
+		}
+		mu.Unlock()
+		return newResponse(http.StatusOK, "{}"), nil
+	}))
+
+	for i := range 8 {
+		traceID := uuid.New()
+		trace := fmt.Sprintf("trace-%d", i)
+		subject.AddEvent(context.TODO(), &types.TraceEvent{ID: &traceID, Name: trace + " step 0"})
+		for step := 1; step <= 3; step++ {
+			subject.AddEvent(context.TODO(), &types.SpanEvent{TraceID: &traceID, Name: fmt.Sprintf("%s step %d", trace, step)})
+		}
+	}
+	require.NoError(t, subject.Stop(context.TODO()))
+
+	require.Len(t, sent, 8)
+	for trace, steps := range sent {
+		assert.Equal(t, []int{0, 1, 2, 3}, steps, trace)
+	}
+}
+
+func Test_PartitionByTrace_ShouldSendEventsOfATraceInOrderWhateverTheirPriority(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.NumberOfEventProcessor = 2
+	cfg.BatchSize = 1
+	cfg.PartitionByTrace = true
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var sent []string
+	subject := newTestService(t, cfg, mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var request struct {
+			Batch []struct {
+				Body struct {
+					Name string `json:"name"`
+				} `json:"body"`
+			} `json:"batch"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return nil, err
+		}
+		// Hold the first request back so the rest of the trace queues up behind it
+		<-release
+		mu.Lock()
+		for _, event := range request.Batch {
+			sent = append(sent, event.Body.Name)
+		}
+		mu.Unlock()
+		return newResponse(http.StatusOK, "{}"), nil
+	}))
+
+	traceID := uuid.New()
+	traceIDString := traceID.String()
+	subject.AddEvent(context.TODO(), &types.TraceEvent{ID: &traceID, Name: "trace"})
+	subject.AddEvent(context.TODO(), &types.SpanEvent{TraceID: &traceID, Name: "debug", Level: types.Debug})
+	subject.AddEvent(context.TODO(), &types.SpanEvent{TraceID: &traceID, Name: "span"})
+	subject.AddEvent(context.TODO(), &types.SpanEvent{TraceID: &traceID, Name: "error", Level: types.Error})
+	subject.AddEvent(context.TODO(), &types.ScoreEvent{TraceID: &traceIDString, Name: "score", Value: 1})
+	close(release)
+	require.NoError(t, subject.Stop(context.TODO()))
+
+	assert.Equal(t, []string{"trace", "debug", "span", "error", "score"}, sent)
+}
+
+func Test_Flush_WithCoalesceEvents_ShouldMergeEventsWithTheSameID(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.CoalesceEvents = true
+	recorder := &requestRecorder{}
+	subject := newTestService(t, cfg, recorder)
+
+	spanID := uuid.New()
+	startTime := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	endTime := startTime.Add(time.Second)
+	started := &types.SpanEvent{ID: &spanID, Name: "example", StartTime: &startTime}
+	ended := &types.SpanEvent{ID: &spanID, EndTime: &endTime, Level: types.Warning}
+	subject.AddEvent(context.TODO(), started)
+	subject.AddEvent(context.TODO(), ended)
+
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+
+	assert.Equal(t, langfuse.FlushResult{EventsSent: 1}, result)
+	assert.Equal(t, int64(1), subject.GetMetrics().EventsCoalesced)
+	requests := recorder.requests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, 1, strings.Count(requests[0], `"type":"span-create"`))
+	assert.Contains(t, requests[0], `"name":"example"`)
+	assert.Contains(t, requests[0], `"startTime":"2025-01-01T10:00:00Z"`)
+	assert.Contains(t, requests[0], `"endTime":"2025-01-01T10:00:01Z"`)
+	assert.Contains(t, requests[0], `"level":"WARNING"`)
+	assert.Nil(t, started.EndTime, "added events must not be modified")
+}
+
+func Test_Flush_WithCoalesceEvents_ShouldNotMergeEventsSentToDifferentDestinations(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.CoalesceEvents = true
+	var mu sync.Mutex
+	sent := make(map[string]string) // public key to request body
+	subject := newTestService(t, cfg, mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, _, _ := req.BasicAuth()
+		mu.Lock()
+		sent[publicKey] += string(body)
+		mu.Unlock()
+		return newResponse(http.StatusOK, "{}"), nil
+	}), langfuse.WithDestinations(langfuse.Destination{Name: "acme", PublicKey: "pk-acme", SecretKey: "sk-acme"}))
+
+	spanID := uuid.New()
+	subject.AddEvent(langfuse.ContextWithDestination(context.TODO(), "acme"), &types.SpanEvent{ID: &spanID, Name: "acme"})
+	subject.AddEvent(context.TODO(), &types.SpanEvent{ID: &spanID, Name: "default"})
+
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+
+	assert.Equal(t, langfuse.FlushResult{EventsSent: 2}, result)
+	assert.Zero(t, subject.GetMetrics().EventsCoalesced)
+	assert.Contains(t, sent["pk-acme"], `"name":"acme"`)
+	assert.NotContains(t, sent["pk-acme"], `"name":"default"`)
+	assert.Contains(t, sent[cfg.PublicKey], `"name":"default"`)
+	assert.NotContains(t, sent[cfg.PublicKey], `"name":"acme"`)
+}
@@ -0,0 +1,77 @@
+package langfuse
+
+import (
+	"context"
+
+	"github.com/xops-infra/GoLangfuse/logger"
+)
+
+// serviceState lifecycle state of the langfuse service
+//
+// The service moves strictly forward: starting -> running -> draining -> stopped.
+// Events are only accepted while running, draining sends whatever is already queued.
+type serviceState int32
+
+const (
+	stateStarting serviceState = iota // processors are being started
+	stateRunning                      // events are accepted and processed
+	stateDraining                     // Stop was called, queued events are being flushed
+	stateStopped                      // all processors have exited
+)
+
+// String returns the human-readable name of the state
+func (s serviceState) String() string {
+	switch s {
+	case stateStarting:
+		return "starting"
+	case stateRunning:
+		return "running"
+	case stateDraining:
+		return "draining"
+	case stateStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// currentState returns the current lifecycle state of the service
+func (l *langfuseService) currentState() serviceState {
+	return serviceState(l.state.Load())
+}
+
+// setState moves the service to the given lifecycle state
+func (l *langfuseService) setState(state serviceState) {
+	l.state.Store(int32(state))
+}
+
+// beginShutdown moves the service to draining, stops accepting new events and
+// closes the queues so processors flush what is left. Once all processors have exited
+// the service is marked as stopped and the done channel is closed.
+func (l *langfuseService) beginShutdown() {
+	l.setState(stateDraining)
+	for _, queue := range l.queues {
+		queue.close()
+	}
+
+	go func() {
+		l.wg.Wait()
+		l.finishShutdown()
+	}()
+}
+
+// finishShutdown closes the spool and the resources owned by the service once nothing is sent anymore,
+// marks the service as stopped and closes the done channel
+func (l *langfuseService) finishShutdown() {
+	log := logger.FromContext(context.Background())
+	if err := l.spool.close(); err != nil {
+		log.WithError(err).Warn("failed to close langfuse spool")
+	}
+	for _, closer := range l.closers {
+		if err := closer.Close(); err != nil {
+			log.WithError(err).Warn("failed to close langfuse resource")
+		}
+	}
+	l.setState(stateStopped)
+	l.metricsCollector.UpdateActiveProcessors(0)
+	close(l.done)
+}
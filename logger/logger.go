@@ -40,3 +40,17 @@ func WithFields(ctx context.Context, key any, fields logrus.Fields) context.Cont
 	maps.Copy(fields, getFields(ctx, key))
 	return context.WithValue(ctx, key, fields)
 }
+
+// Detach returns a background context carrying only the logger fields of ctx,
+// so work outliving ctx keeps logging with them without being cancelled along with it.
+func Detach(ctx context.Context) context.Context {
+	detached := context.Background()
+	if fieldsCtxKey == nil {
+		return detached
+	}
+
+	if fields, ok := ctx.Value(fieldsCtxKey).(logrus.Fields); ok {
+		detached = context.WithValue(detached, fieldsCtxKey, fields)
+	}
+	return detached
+}
@@ -1,6 +1,8 @@
 package langfuse
 
 import (
+	"maps"
+	"slices"
 	"sync"
 	"time"
 )
@@ -33,7 +35,7 @@ const (
 // Metrics contains comprehensive performance and operational metrics for the GoLangfuse client.
 //
 // This struct provides detailed insights into the client's operation including:
-//   - Event processing statistics (processed, queued, failed)
+//   - Event processing statistics (processed, queued, failed, dropped)
 //   - Batch processing metrics (batches processed and failed)
 //   - HTTP request performance (total, success, failure counts and response times)
 //   - Resource utilization (active processors, queue usage)
@@ -67,6 +69,38 @@ type Metrics struct {
 	// or sent to the API, even after retries.
 	EventsFailed int64 `json:"events_failed"`
 
+	// EventsDropped is the total number of events discarded by the queue
+	// overflow policy without being sent to the API.
+	EventsDropped int64 `json:"events_dropped"`
+
+	// EventsDeadLettered is the total number of failed events handed to
+	// the dead letter sink.
+	EventsDeadLettered int64 `json:"events_dead_lettered"`
+
+	// EventsSampledOut is the number of events accepted but not sent
+	// because their trace was not sampled.
+	EventsSampledOut int64 `json:"events_sampled_out"`
+
+	// EventsDroppedByInterceptor is the number of events dropped by each
+	// interceptor, keyed by the interceptor name.
+	EventsDroppedByInterceptor map[string]int64 `json:"events_dropped_by_interceptor,omitempty"`
+
+	// Redactions is the number of values redacted from event payloads,
+	// keyed by the redaction rule name, "denied_field" for denied field paths.
+	Redactions map[string]int64 `json:"redactions,omitempty"`
+
+	// ValuesTruncated is the number of strings and arrays in event payloads
+	// truncated to the configured limits.
+	ValuesTruncated int64 `json:"values_truncated"`
+
+	// EventsCoalesced is the number of events merged into another pending
+	// event with the same ID and type instead of being sent separately.
+	EventsCoalesced int64 `json:"events_coalesced"`
+
+	// CurrentBatchSize is the number of events a batch is sent at, either
+	// the configured BatchSize or the size chosen by adaptive batching.
+	CurrentBatchSize int `json:"current_batch_size"`
+
 	// BatchesProcessed is the total number of event batches successfully
 	// sent to the Langfuse API.
 	BatchesProcessed int64 `json:"batches_processed"`
@@ -103,10 +137,35 @@ type Metrics struct {
 	// HTTP request to the API.
 	MinResponseTime time.Duration `json:"min_response_time"`
 
+	// RetriesDenied is the number of retries that were not made because
+	// the retry budget was used up.
+	RetriesDenied int64 `json:"retries_denied"`
+
+	// RateLimitWaits is the number of requests that waited for the
+	// client-side rate limit before being sent.
+	RateLimitWaits int64 `json:"rate_limit_waits"`
+
+	// RateLimitWaitTime is the cumulative time requests spent waiting
+	// for the client-side rate limit.
+	RateLimitWaitTime time.Duration `json:"rate_limit_wait_time"`
+
+	// RequestBytesUncompressed is the cumulative size of the request
+	// bodies sent to the API before compression.
+	RequestBytesUncompressed int64 `json:"request_bytes_uncompressed"`
+
+	// RequestBytesSent is the cumulative size of the request bodies
+	// sent to the API after compression, equal to the uncompressed size
+	// when compression is off.
+	RequestBytesSent int64 `json:"request_bytes_sent"`
+
 	// ActiveProcessors is the current number of active goroutines
 	// processing events.
 	ActiveProcessors int `json:"active_processors"`
 
+	// SyncMode reports whether events are sent inline by the caller
+	// instead of by background processors.
+	SyncMode bool `json:"sync_mode"`
+
 	// QueueSize is the current number of events waiting in the queue
 	// to be processed.
 	QueueSize int `json:"queue_size"`
@@ -115,6 +174,26 @@ type Metrics struct {
 	// When this limit is reached, new events may be dropped or block.
 	QueueCapacity int `json:"queue_capacity"`
 
+	// SpoolSize is the current number of bytes held by the disk spool.
+	// Zero when spooling is disabled.
+	SpoolSize int64 `json:"spool_size"`
+
+	// EventsReplayed is the number of undelivered events recovered from
+	// the disk spool when the client was initialized.
+	EventsReplayed int64 `json:"events_replayed"`
+
+	// SpoolCorruptRecords is the number of spool records that could not be
+	// read back and were skipped during replay.
+	SpoolCorruptRecords int64 `json:"spool_corrupt_records"`
+
+	// CircuitBreakerState is the state of the circuit breaker guarding the API:
+	// "closed", "open" or "half-open". Empty when the circuit breaker is disabled.
+	CircuitBreakerState string `json:"circuit_breaker_state,omitempty"`
+
+	// Destinations holds the metrics of each project events are sent to,
+	// keyed by destination name. The project of the service config is "default".
+	Destinations map[string]DestinationMetrics `json:"destinations,omitempty"`
+
 	// StartTime is when the metrics collection began (typically when
 	// the client was initialized).
 	StartTime time.Time `json:"start_time"`
@@ -132,6 +211,22 @@ type Metrics struct {
 	LastError string `json:"last_error,omitempty"`
 }
 
+// DestinationMetrics contains the metrics of a single project events are routed to.
+type DestinationMetrics struct {
+	// EventsQueued is the number of events queued for the destination.
+	EventsQueued int64 `json:"events_queued"`
+
+	// EventsProcessed is the number of events delivered to the destination.
+	EventsProcessed int64 `json:"events_processed"`
+
+	// EventsFailed is the number of events that could not be delivered to the destination.
+	EventsFailed int64 `json:"events_failed"`
+
+	// CircuitBreakerState is the state of the circuit breaker guarding the destination.
+	// Empty when the circuit breaker is disabled.
+	CircuitBreakerState string `json:"circuit_breaker_state,omitempty"`
+}
+
 // HealthStatus provides a comprehensive health assessment of the GoLangfuse client.
 //
 // The health status includes overall status and component-specific health indicators
@@ -176,9 +271,13 @@ type HealthStatus struct {
 	ProcessorHealth ComponentHealthValue `json:"processor_health"`
 
 	// APIHealth indicates the health of API connectivity.
-	// Based on HTTP request success/failure rates.
+	// Based on HTTP request success/failure rates and the circuit breaker state.
 	APIHealth ComponentHealthValue `json:"api_health"`
 
+	// CircuitBreakerState is the state of the circuit breaker guarding the API.
+	// Empty when the circuit breaker is disabled.
+	CircuitBreakerState string `json:"circuit_breaker_state,omitempty"`
+
 	// LastHealthCheck is when this health status was last updated.
 	LastHealthCheck time.Time `json:"last_health_check"`
 
@@ -307,6 +406,32 @@ func (mc *MetricsCollector) IncrementEventsFailed(err error) {
 	}
 }
 
+// IncrementEventsDropped increments the dropped events counter.
+//
+// This method should be called each time the queue overflow policy discards
+// an event, either the one being added or an older one evicted to make room.
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) IncrementEventsDropped() {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.metrics.EventsDropped++
+}
+
+// IncrementEventsDeadLettered increments the dead-lettered events counter.
+//
+// This method should be called each time an event that permanently failed to be
+// delivered is stored by the dead letter sink.
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) IncrementEventsDeadLettered() {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.metrics.EventsDeadLettered++
+}
+
 // IncrementBatchesProcessed increments the processed batches counter.
 //
 // This method should be called each time a batch of events is successfully
@@ -422,6 +547,227 @@ func (mc *MetricsCollector) UpdateQueueMetrics(size, capacity int) {
 	mc.metrics.QueueCapacity = capacity
 }
 
+// UpdateSpoolSize updates the number of bytes currently held by the disk spool.
+//
+// Parameters:
+//   - size: total size of the spool segments in bytes
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) UpdateSpoolSize(size int64) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.metrics.SpoolSize = size
+}
+
+// RecordSpoolReplay records the outcome of reading the disk spool at start-up.
+//
+// Parameters:
+//   - replayed: number of undelivered events recovered from the spool
+//   - corrupt: number of records that could not be read and were skipped
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) RecordSpoolReplay(replayed, corrupt int) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.metrics.EventsReplayed += int64(replayed)
+	mc.metrics.SpoolCorruptRecords += int64(corrupt)
+}
+
+// IncrementEventsSampledOut increments the count of events not sent because their trace was not sampled.
+//
+// Sampled out events are accepted by AddEvent and SubmitEvent but never queued.
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) IncrementEventsSampledOut() {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.metrics.EventsSampledOut++
+}
+
+// IncrementInterceptorDrops increments the count of events dropped by the named interceptor.
+//
+// Dropped events are accepted by AddEvent and SubmitEvent but never queued.
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) IncrementInterceptorDrops(name string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.metrics.EventsDroppedByInterceptor == nil {
+		mc.metrics.EventsDroppedByInterceptor = make(map[string]int64)
+	}
+	mc.metrics.EventsDroppedByInterceptor[name]++
+}
+
+// RecordRedactions adds the redactions made in an event payload to the per-rule counts.
+//
+// Parameters:
+//   - counts: number of redactions per rule name
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) RecordRedactions(counts map[string]int) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.metrics.Redactions == nil {
+		mc.metrics.Redactions = make(map[string]int64)
+	}
+	for rule, count := range counts {
+		mc.metrics.Redactions[rule] += int64(count)
+	}
+}
+
+// RecordTruncations adds the strings and arrays truncated in an event payload to the count.
+//
+// Parameters:
+//   - truncated: number of values truncated
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) RecordTruncations(truncated int) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.metrics.ValuesTruncated += int64(truncated)
+}
+
+// IncrementEventsCoalesced increments the count of events merged into another pending event.
+//
+// Coalesced events are delivered as part of the event they were merged into
+// and are not counted again as processed.
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) IncrementEventsCoalesced() {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.metrics.EventsCoalesced++
+}
+
+// UpdateBatchSize records the number of events a batch is currently sent at.
+//
+// Called at start-up with the configured size and, with adaptive batching,
+// whenever the size is adapted after a batch request.
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) UpdateBatchSize(size int) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.metrics.CurrentBatchSize = size
+}
+
+// IncrementRetriesDenied increments the count of retries not made because the retry budget was used up.
+//
+// A growing count means requests keep failing and retries are being shed to protect the API.
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) IncrementRetriesDenied() {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.metrics.RetriesDenied++
+}
+
+// RecordRateLimitWait records a request that waited for the client-side rate limit.
+//
+// Parameters:
+//   - wait: how long the request waited for tokens before being sent
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) RecordRateLimitWait(wait time.Duration) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.metrics.RateLimitWaits++
+	mc.metrics.RateLimitWaitTime += wait
+}
+
+// RecordRequestBytes records the size of a request body sent to the API.
+//
+// Parameters:
+//   - uncompressed: size of the body before compression
+//   - sent: size of the body as sent, after compression if any
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) RecordRequestBytes(uncompressed, sent int) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.metrics.RequestBytesUncompressed += int64(uncompressed)
+	mc.metrics.RequestBytesSent += int64(sent)
+}
+
+// UpdateCircuitBreakerState records the current state of the circuit breaker.
+//
+// Called by the circuit breaker whenever it moves between "closed", "open"
+// and "half-open". An open breaker makes the API health critical.
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) UpdateCircuitBreakerState(state string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.metrics.CircuitBreakerState = state
+}
+
+// IncrementDestinationEventsQueued increments the queued events counter of a destination.
+//
+// Parameters:
+//   - name: name of the destination the event is routed to
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) IncrementDestinationEventsQueued(name string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.updateDestination(name, func(m *DestinationMetrics) {
+		m.EventsQueued++
+	})
+}
+
+// RecordDestinationResult records the outcome of sending a batch to a destination.
+//
+// Parameters:
+//   - name: name of the destination the batch was sent to
+//   - sent: number of events delivered
+//   - failed: number of events that could not be delivered
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) RecordDestinationResult(name string, sent, failed int) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.updateDestination(name, func(m *DestinationMetrics) {
+		m.EventsProcessed += int64(sent)
+		m.EventsFailed += int64(failed)
+	})
+}
+
+// UpdateDestinationCircuitBreakerState records the current state of the circuit breaker of a destination.
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) UpdateDestinationCircuitBreakerState(name, state string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.updateDestination(name, func(m *DestinationMetrics) {
+		m.CircuitBreakerState = state
+	})
+}
+
+// updateDestination applies update to the metrics of the named destination. Must be called with mu locked.
+func (mc *MetricsCollector) updateDestination(name string, update func(*DestinationMetrics)) {
+	if mc.metrics.Destinations == nil {
+		mc.metrics.Destinations = make(map[string]DestinationMetrics)
+	}
+	metrics := mc.metrics.Destinations[name]
+	update(&metrics)
+	mc.metrics.Destinations[name] = metrics
+}
+
 // UpdateActiveProcessors updates the count of active event processor goroutines.
 //
 // This method should be called when processor goroutines are started or stopped
@@ -439,6 +785,16 @@ func (mc *MetricsCollector) UpdateActiveProcessors(count int) {
 	mc.metrics.ActiveProcessors = count
 }
 
+// UpdateSyncMode records whether the service sends events inline without processors.
+//
+// Thread-safe for concurrent access.
+func (mc *MetricsCollector) UpdateSyncMode(enabled bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.metrics.SyncMode = enabled
+}
+
 // GetMetrics returns a copy of the current metrics snapshot.
 //
 // This method provides a consistent view of all metrics at the time of the call.
@@ -459,7 +815,11 @@ func (mc *MetricsCollector) GetMetrics() Metrics {
 	mc.mu.RLock()
 	defer mc.mu.RUnlock()
 
-	return *mc.metrics
+	metrics := *mc.metrics
+	metrics.EventsDroppedByInterceptor = maps.Clone(mc.metrics.EventsDroppedByInterceptor)
+	metrics.Redactions = maps.Clone(mc.metrics.Redactions)
+	metrics.Destinations = maps.Clone(mc.metrics.Destinations)
+	return metrics
 }
 
 // CheckHealth performs comprehensive health assessment and returns detailed health status.
@@ -468,6 +828,8 @@ func (mc *MetricsCollector) GetMetrics() Metrics {
 //   - Queue Health: Based on queue utilization (>90% critical, >70% warning)
 //   - Processor Health: Based on active processor count (0 is critical)
 //   - API Health: Based on HTTP request error rates (>10% critical, >5% warning)
+//     and the circuit breaker (open is critical, half-open a warning)
+//   - Destinations: A warning for each routed destination with an open circuit breaker
 //   - Recent Errors: Warnings for errors within the last 5 minutes
 //
 // Health Status Levels:
@@ -525,8 +887,8 @@ func (mc *MetricsCollector) CheckHealth() HealthStatus {
 		health.QueueHealth = cmpHealthHealthy
 	}
 
-	// Check processor health
-	if mc.metrics.ActiveProcessors == 0 {
+	// Check processor health, none are started in sync mode
+	if mc.metrics.ActiveProcessors == 0 && !mc.metrics.SyncMode {
 		health.ProcessorHealth = cmpHealthCritical
 		health.Errors = append(health.Errors, "No active processors")
 		health.Status = healthStatusUnhealthy
@@ -555,6 +917,34 @@ func (mc *MetricsCollector) CheckHealth() HealthStatus {
 		health.APIHealth = cmpHealthUnknown
 	}
 
+	// Check the circuit breaker, an open breaker means events are not being sent
+	health.CircuitBreakerState = mc.metrics.CircuitBreakerState
+	switch mc.metrics.CircuitBreakerState {
+	case breakerOpen.String():
+		health.APIHealth = cmpHealthCritical
+		health.Errors = append(health.Errors, "Circuit breaker open, events are held back")
+		health.Status = healthStatusUnhealthy
+	case breakerHalfOpen.String():
+		if health.APIHealth != cmpHealthCritical {
+			health.APIHealth = cmpHealthWarning
+		}
+		health.Warnings = append(health.Warnings, "Circuit breaker half-open, probing the API")
+		if health.Status == healthStatusHealthy {
+			health.Status = healthStatusDegraded
+		}
+	}
+
+	// Check the circuit breakers of routed destinations, other destinations are still being sent to
+	for _, name := range slices.Sorted(maps.Keys(mc.metrics.Destinations)) {
+		if name == DefaultDestination || mc.metrics.Destinations[name].CircuitBreakerState != breakerOpen.String() {
+			continue
+		}
+		health.Warnings = append(health.Warnings, "Circuit breaker open for destination "+name+", its events are held back")
+		if health.Status == healthStatusHealthy {
+			health.Status = healthStatusDegraded
+		}
+	}
+
 	// Check for recent errors
 	if mc.metrics.LastErrorAt != nil && now.Sub(*mc.metrics.LastErrorAt) < 5*time.Minute {
 		health.Warnings = append(health.Warnings, "Recent errors detected")
@@ -0,0 +1,140 @@
+package langfuse
+
+// Option configures optional behaviour of the langfuse service that cannot be expressed
+// through config.Langfuse, such as custom implementations of extension points.
+//
+// Example:
+//
+//	sink, _ := langfuse.NewFileDeadLetterSink("/var/lib/myapp/langfuse-dead-letters.jsonl")
+//	service, err := langfuse.New(cfg, langfuse.WithDeadLetterSink(sink))
+type Option func(*langfuseService)
+
+// WithDeadLetterSink sets the sink receiving events that permanently failed to be delivered.
+// It takes precedence over config.Langfuse.DeadLetterFile.
+func WithDeadLetterSink(sink DeadLetterSink) Option {
+	return func(l *langfuseService) {
+		l.deadLetterSink = sink
+	}
+}
+
+// WithPriorityClassifier sets how events are assigned a priority lane, replacing DefaultPriority.
+func WithPriorityClassifier(classifier PriorityClassifier) Option {
+	return func(l *langfuseService) {
+		l.classifier = classifier
+	}
+}
+
+// WithPropagatedContextKeys sets context keys whose values are carried over to event delivery,
+// such as trace IDs read by a custom logger. Events are delivered with a context detached from
+// the caller's, which otherwise only keeps the logger fields.
+func WithPropagatedContextKeys(keys ...any) Option {
+	return func(l *langfuseService) {
+		l.contextKeys = append(l.contextKeys, keys...)
+	}
+}
+
+// WithSamplingRules sets the rules deciding the fraction of traces kept per trace name, environment
+// and observation level. Traces not matching any rule are kept at config.Langfuse.SampleRate.
+// Observations and scores follow the decision made for their trace, see SamplingRule.
+//
+// Example:
+//
+//	service, err := langfuse.New(cfg, langfuse.WithSamplingRules(
+//		langfuse.SamplingRule{Name: "health-check", Rate: 0},
+//		langfuse.SamplingRule{Level: types.Debug, Rate: 0.01},
+//		langfuse.SamplingRule{Environment: "production", Rate: 0.1},
+//	))
+func WithSamplingRules(rules ...SamplingRule) Option {
+	return func(l *langfuseService) {
+		l.samplingRules = append(l.samplingRules, rules...)
+	}
+}
+
+// WithDestinations adds Langfuse projects events can be routed to besides the one of config.Langfuse,
+// each with its own keys, batches, circuit breaker and metrics. Events are routed by WithRouter,
+// by default from ContextWithDestination or the DestinationMetadataKey metadata entry.
+//
+// Example:
+//
+//	service, err := langfuse.New(cfg, langfuse.WithDestinations(
+//		langfuse.Destination{Name: "acme", PublicKey: "pk-acme", SecretKey: "sk-acme"},
+//		langfuse.Destination{Name: "globex", URL: "https://eu.langfuse.example", PublicKey: "pk-globex", SecretKey: "sk-globex"},
+//	))
+//	service.AddEvent(langfuse.ContextWithDestination(ctx, "acme"), trace)
+func WithDestinations(destinations ...Destination) Option {
+	return func(l *langfuseService) {
+		l.routes = append(l.routes, destinations...)
+	}
+}
+
+// WithRouter sets how the destination of an event is picked, replacing RouteByContext.
+// The router is only consulted when destinations were added with WithDestinations.
+func WithRouter(router Router) Option {
+	return func(l *langfuseService) {
+		l.router = router
+	}
+}
+
+// WithExporter sets where events are delivered instead of the Langfuse API, such as NewStdoutExporter
+// for local development or NewFanOutExporter to keep an audit copy. Events routed to destinations
+// added with WithDestinations are still sent to their projects.
+//
+// Example:
+//
+//	service, err := langfuse.New(cfg, langfuse.WithExporter(langfuse.NewStdoutExporter()))
+func WithExporter(exporter Exporter) Option {
+	return func(l *langfuseService) {
+		l.exporter = exporter
+	}
+}
+
+// WithInterceptor appends an interceptor to the chain every event goes through before it is queued,
+// in the order the interceptors were added. Events it drops are counted under name in
+// Metrics.EventsDroppedByInterceptor.
+//
+// Example:
+//
+//	service, err := langfuse.New(cfg,
+//		langfuse.WithInterceptor("release", addRelease),
+//		langfuse.WithInterceptor("noisy-spans", langfuse.DropSpansNamed("cache-lookup", "health-check")),
+//	)
+func WithInterceptor(name string, interceptor Interceptor) Option {
+	return func(l *langfuseService) {
+		l.interceptors = append(l.interceptors, namedInterceptor{name: name, interceptor: interceptor})
+	}
+}
+
+// WithRedaction redacts personal data and secrets from the Input, Output and Metadata of traces, spans
+// and generations before they are queued. Redactions are counted per rule in Metrics.Redactions.
+//
+// Example:
+//
+//	service, err := langfuse.New(cfg, langfuse.WithRedaction(langfuse.RedactionConfig{
+//		Rules:    append(langfuse.DefaultRedactionRules(), langfuse.RedactionRule{Name: "order", Pattern: regexp.MustCompile(`ORD-\d{8}`)}),
+//		Strategy: langfuse.RedactHash,
+//		Allow:    []string{"metadata.request_id"},
+//		Deny:     []string{"input.password", "metadata.*.token"},
+//	}))
+func WithRedaction(cfg RedactionConfig) Option {
+	return func(l *langfuseService) {
+		l.redactor = newRedactor(cfg)
+	}
+}
+
+// WithTruncationLimits sets the truncation limits of an event type, such as generation-create,
+// replacing config.Langfuse.MaxStringBytes and MaxArrayItems for events of that type.
+//
+// Example:
+//
+//	service, err := langfuse.New(cfg,
+//		langfuse.WithTruncationLimits("generation-create", langfuse.TruncationLimits{MaxStringBytes: 64 << 10, MaxArrayItems: 50}),
+//		langfuse.WithTruncationLimits("trace-create", langfuse.TruncationLimits{MaxStringBytes: 4 << 10}),
+//	)
+func WithTruncationLimits(eventType string, limits TruncationLimits) Option {
+	return func(l *langfuseService) {
+		if l.truncationLimits == nil {
+			l.truncationLimits = make(map[string]TruncationLimits)
+		}
+		l.truncationLimits[eventType] = limits
+	}
+}
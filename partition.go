@@ -0,0 +1,87 @@
+package langfuse
+
+import (
+	"hash/fnv"
+
+	"github.com/xops-infra/GoLangfuse/config"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// newEventQueues creates the queue shared by all processors or, when events are partitioned by trace,
+// one queue per processor splitting the configured capacity between them
+func newEventQueues(cfg *config.Langfuse) []*eventQueue {
+	capacity := cfg.QueueCapacity
+	if capacity <= 0 {
+		capacity = defaultQueueCapacity
+	}
+
+	if !cfg.PartitionByTrace || cfg.NumberOfEventProcessor <= 1 {
+		return []*eventQueue{newEventQueue(cfg, capacity)}
+	}
+
+	partitions := cfg.NumberOfEventProcessor
+	queues := make([]*eventQueue, partitions)
+	for i := range queues {
+		queues[i] = newEventQueue(cfg, max(1, (capacity+partitions-1)/partitions))
+	}
+	return queues
+}
+
+// queueFor returns the queue of the event, the same one for every event of a trace
+func (l *langfuseService) queueFor(event types.LangfuseEvent) *eventQueue {
+	if len(l.queues) == 1 {
+		return l.queues[0]
+	}
+
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(traceKey(event)))
+	return l.queues[hash.Sum32()%uint32(len(l.queues))] //nolint:gosec // the number of queues fits in uint32
+}
+
+// processorQueue returns the queue the processor takes its events from
+func (l *langfuseService) processorQueue(processorID int) *eventQueue {
+	return l.queues[processorID%len(l.queues)]
+}
+
+// updateQueueMetrics records the number of queued events and the capacity over all queues
+func (l *langfuseService) updateQueueMetrics() {
+	if l.buffer != nil {
+		// Only called with the buffer locked in sync mode
+		l.metricsCollector.UpdateQueueMetrics(len(l.buffer.batch.items), l.buffer.capacity)
+		return
+	}
+
+	size, capacity := 0, 0
+	for _, queue := range l.queues {
+		size += queue.len()
+		capacity += queue.capacity()
+	}
+	l.metricsCollector.UpdateQueueMetrics(size, capacity)
+}
+
+// traceKey returns the trace ID of the event, falling back to the event ID for events not attached to a trace
+func traceKey(event types.LangfuseEvent) string {
+	switch e := event.(type) {
+	case *types.TraceEvent:
+		if e.ID != nil {
+			return e.ID.String()
+		}
+	case *types.SpanEvent:
+		if e.TraceID != nil {
+			return e.TraceID.String()
+		}
+	case *types.GenerationEvent:
+		if e.TraceID != nil {
+			return e.TraceID.String()
+		}
+	case *types.ScoreEvent:
+		if e.TraceID != nil {
+			return *e.TraceID
+		}
+	}
+
+	if id := event.GetID(); id != nil {
+		return id.String()
+	}
+	return ""
+}
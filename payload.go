@@ -0,0 +1,115 @@
+package langfuse
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// walkAction what walkPayload does with a value after it was visited
+type walkAction int
+
+const (
+	walkDescend walkAction = iota // keep the value, walking into it when it is an object or array
+	walkKeep                      // keep the value as returned without walking into it
+	walkRemove                    // remove the value from its parent
+)
+
+// payloadVisitor is called for every value of a payload with its field path, parents before children.
+// It returns the value to keep, possibly replaced, and what to do with it.
+type payloadVisitor func(path string, value any) (any, walkAction)
+
+// walkPayload returns value with every nested value passed through visit, false when the value itself was removed.
+// Objects and arrays are copied so the caller's values are never modified, values that are not plain JSON,
+// such as structs and typed maps or slices, are converted to their JSON form first.
+// Paths join object keys with dots, e.g. metadata.user.email, array elements share the path of their array.
+func walkPayload(path string, value any, visit payloadVisitor) (any, bool) {
+	value, action := visit(path, plainJSON(value))
+	switch action {
+	case walkRemove:
+		return nil, false
+	case walkKeep:
+		return value, true
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		walked := make(map[string]any, len(v))
+		for key, child := range v {
+			if child, ok := walkPayload(path+"."+key, child, visit); ok {
+				walked[key] = child
+			}
+		}
+		return walked, true
+	case []any:
+		walked := make([]any, 0, len(v))
+		for _, child := range v {
+			if child, ok := walkPayload(path, child, visit); ok {
+				walked = append(walked, child)
+			}
+		}
+		return walked, true
+	}
+	return value, true
+}
+
+// plainJSON returns the value as strings, numbers, booleans, nil, map[string]any and []any,
+// converting other values through their JSON encoding. Values that cannot be encoded are returned as is.
+func plainJSON(value any) any {
+	switch value.(type) {
+	case nil, string, bool, float64, float32, int, int64, int32, uint, uint64, uint32, json.Number, map[string]any, []any:
+		return value
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var plain any
+	if err := decoder.Decode(&plain); err != nil {
+		return value
+	}
+	return plain
+}
+
+// rewritePayload walks the Input, Output and Metadata of traces, spans and generations and returns
+// a shallow copy of the event holding the walked values, the caller's event is left untouched.
+// Other events are returned as is. Paths start with input, output and metadata.
+func rewritePayload(event types.LangfuseEvent, visit payloadVisitor) types.LangfuseEvent {
+	var input, output *any
+	var metadata *map[string]any
+	switch e := event.(type) {
+	case *types.TraceEvent:
+		rewritten := *e
+		event, input, output, metadata = &rewritten, &rewritten.Input, &rewritten.Output, &rewritten.Metadata
+	case *types.SpanEvent:
+		rewritten := *e
+		event, input, output, metadata = &rewritten, &rewritten.Input, &rewritten.Output, &rewritten.Metadata
+	case *types.GenerationEvent:
+		rewritten := *e
+		event, input, output, metadata = &rewritten, &rewritten.Input, &rewritten.Output, &rewritten.Metadata
+	default:
+		return event
+	}
+
+	for path, field := range map[string]*any{"input": input, "output": output} {
+		if *field == nil {
+			continue
+		}
+		walked, ok := walkPayload(path, *field, visit)
+		if !ok {
+			walked = nil
+		}
+		*field = walked
+	}
+
+	if *metadata != nil {
+		walked, _ := walkPayload("metadata", *metadata, visit)
+		rewritten, _ := walked.(map[string]any)
+		*metadata = rewritten
+	}
+	return event
+}
@@ -0,0 +1,66 @@
+package langfuse
+
+import "github.com/xops-infra/GoLangfuse/types"
+
+// Priority delivery class of an event. Under backlog higher priorities are sent first
+// and overflow policies that drop events shed lower priorities first.
+type Priority int
+
+const (
+	PriorityLow    Priority = iota // PriorityLow debug-level observations
+	PriorityNormal                 // PriorityNormal traces and default-level observations
+	PriorityHigh                   // PriorityHigh scores and error-level observations
+
+	priorityCount = int(PriorityHigh) + 1
+)
+
+// priorityWeights how many events of each priority processors take in turn when all lanes have events
+var priorityWeights = [priorityCount]int{
+	PriorityLow:    1,
+	PriorityNormal: 2,
+	PriorityHigh:   4,
+}
+
+// String returns the human-readable name of the priority
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return "unknown"
+}
+
+// PriorityClassifier decides the priority an event is queued with
+type PriorityClassifier func(event types.LangfuseEvent) Priority
+
+// DefaultPriority classifies scores and error-level spans and generations as high priority,
+// debug-level spans and generations as low priority and everything else as normal
+func DefaultPriority(event types.LangfuseEvent) Priority {
+	var level types.Level
+	switch e := event.(type) {
+	case *types.ScoreEvent:
+		return PriorityHigh
+	case *types.SpanEvent:
+		level = e.Level
+	case *types.GenerationEvent:
+		level = e.Level
+	}
+
+	switch level {
+	case types.Error:
+		return PriorityHigh
+	case types.Debug:
+		return PriorityLow
+	}
+	return PriorityNormal
+}
+
+// classify returns the queue lane of the event, clamping priorities returned by custom classifiers
+func (l *langfuseService) classify(event types.LangfuseEvent) Priority {
+	priority := l.classifier(event)
+	return min(max(priority, PriorityLow), PriorityHigh)
+}
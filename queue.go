@@ -0,0 +1,253 @@
+package langfuse
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xops-infra/GoLangfuse/config"
+)
+
+// defaultQueueCapacity is the queue capacity used when none is configured.
+const defaultQueueCapacity = 512
+
+// eventQueue a bounded in-memory queue of events waiting for a processor, with one FIFO lane per priority.
+// The lanes share the capacity, processors take from them in weighted rounds and overflow policies
+// that drop events shed lower priorities first. When the queue is full the configured overflow policy
+// decides whether the caller waits or an event is dropped. A FIFO queue still sheds by priority
+// but hands its items out in the order they were queued, whatever their priority.
+type eventQueue struct {
+	policy  config.OverflowPolicy
+	timeout time.Duration
+	limit   int
+	fifo    bool
+
+	// ready holds a token per queued item so processors can wait for items in a select.
+	// A token may outlive an evicted item, so take can come back empty. It is closed with the queue.
+	ready chan struct{}
+
+	// mu guards the lanes, closing is signalled first so blocked puts give up
+	mu        sync.Mutex
+	lanes     [priorityCount][]eventChanItem
+	length    int
+	credits   [priorityCount]int // credits events each lane may still hand out in the current round
+	seq       uint64             // seq of the next pushed item, the order FIFO queues hand items out in
+	freed     chan struct{}      // freed is closed and replaced whenever an item is taken, waking blocked puts
+	closed    bool
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// newEventQueue creates a queue of the given capacity configured from the langfuse config
+func newEventQueue(cfg *config.Langfuse, capacity int) *eventQueue {
+	policy := cfg.OverflowPolicy
+	if policy == "" {
+		policy = config.OverflowBlock
+	}
+
+	return &eventQueue{
+		policy:  policy,
+		timeout: cfg.EnqueueTimeout,
+		limit:   capacity,
+		fifo:    cfg.PartitionByTrace,
+		ready:   make(chan struct{}, capacity),
+		credits: priorityWeights,
+		freed:   make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+}
+
+// put adds the item to the queue according to the overflow policy.
+// It returns the items evicted to make room for the new one, and ErrQueueFull when the item itself was not queued.
+// ErrServiceStopped is returned once the queue is closed or closing.
+func (q *eventQueue) put(ctx context.Context, item eventChanItem) ([]eventChanItem, error) {
+	switch q.policy {
+	case config.OverflowDropNewest, config.OverflowDropOldest:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		if q.closed {
+			return nil, ErrServiceStopped
+		}
+		if q.length < q.limit {
+			q.push(item)
+			return nil, nil
+		}
+
+		// Queue is full, make room by discarding a lower priority event or drop the new one
+		evicted, ok := q.evict(item.priority, q.policy == config.OverflowDropOldest)
+		if !ok {
+			return nil, ErrQueueFull
+		}
+		q.push(item)
+		return []eventChanItem{evicted}, nil
+
+	case config.OverflowBlockTimeout:
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		return nil, q.wait(ctx, item, timer.C)
+
+	default:
+		return nil, q.wait(ctx, item, nil)
+	}
+}
+
+// putWait adds the item to the queue waiting for free space regardless of the overflow policy
+func (q *eventQueue) putWait(ctx context.Context, item eventChanItem) error {
+	return q.wait(ctx, item, nil)
+}
+
+// wait blocks until the item is queued, the timeout fires, the context is done or the queue is closing
+func (q *eventQueue) wait(ctx context.Context, item eventChanItem, timeout <-chan time.Time) error {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return ErrServiceStopped
+		}
+		if q.length < q.limit {
+			q.push(item)
+			q.mu.Unlock()
+			return nil
+		}
+		freed := q.freed
+		q.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-timeout:
+			return ErrQueueFull.WithDetails(map[string]any{"timeout": q.timeout.String()})
+		case <-ctx.Done():
+			return ErrQueueFull.WithCause(ctx.Err())
+		case <-q.closing:
+			return ErrServiceStopped
+		}
+	}
+}
+
+// push appends the item to its lane. Must be called with mu locked and room in the queue.
+func (q *eventQueue) push(item eventChanItem) {
+	item.seq = q.seq
+	q.seq++
+	q.lanes[item.priority] = append(q.lanes[item.priority], item)
+	q.length++
+	q.ready <- struct{}{}
+}
+
+// evict removes a queued item to make room for one of the given priority, starting with the lowest priority lane.
+// Drop-oldest evicts the oldest item of a lane up to the same priority, otherwise the newest item of a lower priority
+// lane is evicted. Must be called with mu locked.
+func (q *eventQueue) evict(priority Priority, oldest bool) (eventChanItem, bool) {
+	for lane := PriorityLow; lane < priority || (oldest && lane == priority); lane++ {
+		items := q.lanes[lane]
+		if len(items) == 0 {
+			continue
+		}
+
+		var evicted eventChanItem
+		if oldest {
+			evicted = items[0]
+			items[0] = eventChanItem{}
+			q.lanes[lane] = items[1:]
+		} else {
+			evicted = items[len(items)-1]
+			items[len(items)-1] = eventChanItem{}
+			q.lanes[lane] = items[:len(items)-1]
+		}
+		q.length--
+
+		// Drop the evicted item's token, unless a processor already holds it and will come back empty
+		select {
+		case <-q.ready:
+		default:
+		}
+		return evicted, true
+	}
+	return eventChanItem{}, false
+}
+
+// take removes the next item in weighted priority order, to be called after receiving from ready.
+// Within a round the highest priority lane with events and credits left goes first,
+// so lower priorities still get their share under a constant stream of higher priority events.
+// FIFO queues take the oldest item of any lane instead.
+// Returns false when the item the token was for has been evicted.
+func (q *eventQueue) take() (eventChanItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.fifo {
+		return q.takeOldest()
+	}
+
+	for range 2 {
+		for lane := PriorityHigh; lane >= PriorityLow; lane-- {
+			items := q.lanes[lane]
+			if len(items) == 0 || q.credits[lane] == 0 {
+				continue
+			}
+
+			item := items[0]
+			items[0] = eventChanItem{}
+			q.lanes[lane] = items[1:]
+			q.length--
+			q.credits[lane]--
+
+			close(q.freed)
+			q.freed = make(chan struct{})
+			return item, true
+		}
+
+		// Every lane with events used up its credits, start a new round
+		q.credits = priorityWeights
+	}
+	return eventChanItem{}, false
+}
+
+// takeOldest removes the item queued first across all lanes. Must be called with mu locked.
+func (q *eventQueue) takeOldest() (eventChanItem, bool) {
+	oldest := -1
+	for lane, items := range q.lanes {
+		if len(items) > 0 && (oldest < 0 || items[0].seq < q.lanes[oldest][0].seq) {
+			oldest = lane
+		}
+	}
+	if oldest < 0 {
+		return eventChanItem{}, false
+	}
+
+	items := q.lanes[oldest]
+	item := items[0]
+	items[0] = eventChanItem{}
+	q.lanes[oldest] = items[1:]
+	q.length--
+
+	close(q.freed)
+	q.freed = make(chan struct{})
+	return item, true
+}
+
+// close stops accepting new items and closes ready so processors can drain what is left.
+// It is safe to call close more than once and concurrently with put.
+func (q *eventQueue) close() {
+	q.closeOnce.Do(func() {
+		// Release puts blocked on a full queue
+		close(q.closing)
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.closed = true
+		close(q.ready)
+	})
+}
+
+// len returns the number of items currently queued
+func (q *eventQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.length
+}
+
+// capacity returns the maximum number of items the queue can hold
+func (q *eventQueue) capacity() int {
+	return q.limit
+}
@@ -0,0 +1,107 @@
+package langfuse
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket a token bucket refilled at a fixed rate per second, holding at most one second worth of tokens.
+// Takes larger than the bucket are allowed and leave it in deficit, so a large batch waits instead of never fitting.
+// A nil bucket never waits.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket refilled with rate tokens per second, nil when rate is 0
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := math.Max(rate, 1)
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// reserve takes n tokens and returns how long the caller has to wait before using them
+func (b *tokenBucket) reserve(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns n tokens taken by reserve that were not used
+func (b *tokenBucket) cancel(n int) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+float64(n))
+}
+
+// rateLimiter limits ingestion requests per second and events per second. A nil limiter never waits.
+type rateLimiter struct {
+	requests *tokenBucket
+	events   *tokenBucket
+}
+
+// newRateLimiter creates a limiter for the given rates, nil when both are 0
+func newRateLimiter(requestsPerSecond, eventsPerSecond float64) *rateLimiter {
+	if requestsPerSecond <= 0 && eventsPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		requests: newTokenBucket(requestsPerSecond),
+		events:   newTokenBucket(eventsPerSecond),
+	}
+}
+
+// wait blocks until a request carrying the given number of events may be sent and returns how long it waited.
+// The tokens are given back when the context is done first.
+func (l *rateLimiter) wait(ctx context.Context, events int) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+
+	delay := max(l.requests.reserve(1), l.events.reserve(events))
+	if delay <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		l.requests.cancel(1)
+		l.events.cancel(events)
+		return 0, ctx.Err()
+	}
+}
@@ -0,0 +1,230 @@
+package langfuse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// RedactionStrategy how redacted values are replaced
+type RedactionStrategy string
+
+const (
+	// RedactMask replaces the value with a placeholder naming the rule, e.g. [REDACTED:email]
+	RedactMask RedactionStrategy = "mask"
+	// RedactHash replaces the value with a short SHA-256 hash, so equal values can still be correlated
+	RedactHash RedactionStrategy = "hash"
+	// RedactRemove removes the value, matched text is cut out of strings and denied fields are left out
+	RedactRemove RedactionStrategy = "remove"
+)
+
+// redactionDenied name denied fields are counted under in Metrics.Redactions
+const redactionDenied = "denied_field"
+
+// RedactionRule detects sensitive text inside string values
+type RedactionRule struct {
+	Name     string            // Name identifies the rule in placeholders and Metrics.Redactions
+	Pattern  *regexp.Regexp    // Pattern matches the sensitive text
+	Validate func(string) bool // Validate optionally confirms a match, e.g. a checksum, nil accepts every match
+	Strategy RedactionStrategy // Strategy replaces matches, RedactionConfig.Strategy when empty
+}
+
+// Built-in detectors, see DefaultRedactionRules
+var (
+	// RedactEmails detects email addresses
+	RedactEmails = RedactionRule{
+		Name:    "email",
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	}
+	// RedactCreditCards detects card numbers of 13 to 19 digits, optionally separated by spaces or dashes,
+	// that pass the Luhn check
+	RedactCreditCards = RedactionRule{
+		Name:     "credit_card",
+		Pattern:  regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`),
+		Validate: luhnValid,
+	}
+	// RedactPhoneNumbers detects international numbers starting with + and separated ten digit numbers
+	RedactPhoneNumbers = RedactionRule{
+		Name:    "phone",
+		Pattern: regexp.MustCompile(`\+\d{1,3}(?:[ .\-]?\(?\d{2,4}\)?){2,4}\b|\(?\b\d{3}\)?[ .\-]\d{3}[ .\-]\d{4}\b`),
+	}
+	// RedactJWTs detects JSON web tokens
+	RedactJWTs = RedactionRule{
+		Name:    "jwt",
+		Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]+\.eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`),
+	}
+	// RedactAPIKeys detects common API key formats: sk-/pk- prefixed keys (Langfuse, OpenAI, Stripe),
+	// AWS access key IDs, GitHub, Slack and Google API tokens
+	RedactAPIKeys = RedactionRule{
+		Name: "api_key",
+		Pattern: regexp.MustCompile(`\b(?:sk|pk|rk)[\-_](?:live[\-_]|test[\-_]|lf\-|proj\-)?[A-Za-z0-9\-_]{16,}|` +
+			`\bAKIA[0-9A-Z]{16}\b|\bgh[pousr]_[A-Za-z0-9]{36}\b|\bxox[abprs]\-[A-Za-z0-9\-]{10,}|\bAIza[0-9A-Za-z_\-]{35}`),
+	}
+)
+
+// DefaultRedactionRules returns the built-in detectors for JWTs, API keys, emails, credit cards and phone numbers,
+// in the order they are applied
+func DefaultRedactionRules() []RedactionRule {
+	return []RedactionRule{RedactJWTs, RedactAPIKeys, RedactEmails, RedactCreditCards, RedactPhoneNumbers}
+}
+
+// RedactionConfig configures the redaction of Input, Output and Metadata of traces, spans and generations.
+// Field paths join object keys with dots starting at input, output or metadata, e.g. metadata.user.email,
+// array elements share the path of their array and * matches any single key. A path also covers
+// everything nested below it.
+type RedactionConfig struct {
+	Rules    []RedactionRule   // Rules applied to every string in order, DefaultRedactionRules when nil
+	Strategy RedactionStrategy // Strategy default replacement of rule matches and denied fields, RedactMask when empty
+	Allow    []string          // Allow field paths never redacted, e.g. metadata.request_id
+	Deny     []string          // Deny field paths always redacted as a whole, e.g. input.password
+}
+
+// redactor applies a RedactionConfig to events
+type redactor struct {
+	rules    []RedactionRule
+	strategy RedactionStrategy
+	allow    [][]string
+	deny     [][]string
+}
+
+// newRedactor creates a redactor for the config, filling in the defaults
+func newRedactor(cfg RedactionConfig) *redactor {
+	r := &redactor{rules: cfg.Rules, strategy: cfg.Strategy}
+	if r.rules == nil {
+		r.rules = DefaultRedactionRules()
+	}
+	if r.strategy == "" {
+		r.strategy = RedactMask
+	}
+	for _, path := range cfg.Allow {
+		r.allow = append(r.allow, strings.Split(path, "."))
+	}
+	for _, path := range cfg.Deny {
+		r.deny = append(r.deny, strings.Split(path, "."))
+	}
+	return r
+}
+
+// redact returns a copy of the event with its payload redacted and the number of redactions per rule
+func (r *redactor) redact(event types.LangfuseEvent) (types.LangfuseEvent, map[string]int) {
+	counts := make(map[string]int)
+	event = rewritePayload(event, func(path string, value any) (any, walkAction) {
+		segments := strings.Split(path, ".")
+		switch {
+		case matchesPathPrefix(r.allow, segments):
+			return value, walkKeep
+		case matchesPathPrefix(r.deny, segments):
+			counts[redactionDenied]++
+			if r.strategy == RedactRemove {
+				return nil, walkRemove
+			}
+			return r.replaceValue(value), walkKeep
+		}
+
+		if text, ok := value.(string); ok {
+			return r.redactString(text, counts), walkKeep
+		}
+		return value, walkDescend
+	})
+	return event, counts
+}
+
+// redactString replaces the matches of every rule in text
+func (r *redactor) redactString(text string, counts map[string]int) string {
+	for _, rule := range r.rules {
+		strategy := rule.Strategy
+		if strategy == "" {
+			strategy = r.strategy
+		}
+		text = rule.Pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if rule.Validate != nil && !rule.Validate(match) {
+				return match
+			}
+			counts[rule.Name]++
+			switch strategy {
+			case RedactRemove:
+				return ""
+			case RedactHash:
+				return "[" + rule.Name + ":" + shortHash(match) + "]"
+			}
+			return "[REDACTED:" + rule.Name + "]"
+		})
+	}
+	return text
+}
+
+// replaceValue returns the replacement of a denied value
+func (r *redactor) replaceValue(value any) any {
+	if r.strategy != RedactHash {
+		return "[REDACTED]"
+	}
+	text, ok := value.(string)
+	if !ok {
+		encoded, _ := json.Marshal(value)
+		text = string(encoded)
+	}
+	return "[" + shortHash(text) + "]"
+}
+
+// matchesPathPrefix reports whether one of the patterns matches the path or one of its parents
+func matchesPathPrefix(patterns [][]string, path []string) bool {
+	for _, pattern := range patterns {
+		if len(pattern) > len(path) {
+			continue
+		}
+		matched := true
+		for i, segment := range pattern {
+			if segment != "*" && segment != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// shortHash returns the first 12 hex digits of the SHA-256 of the text
+func shortHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// luhnValid reports whether the digits of the match pass the Luhn checksum
+func luhnValid(match string) bool {
+	sum, digits := 0, 0
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// redact returns the event with the configured redaction applied and records the redactions
+func (l *langfuseService) redact(event types.LangfuseEvent) types.LangfuseEvent {
+	if l.redactor == nil {
+		return event
+	}
+	event, counts := l.redactor.redact(event)
+	if len(counts) > 0 {
+		l.metricsCollector.RecordRedactions(counts)
+	}
+	return event
+}
@@ -0,0 +1,167 @@
+package langfuse
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xops-infra/GoLangfuse/config"
+)
+
+// decorrelatedJitterFactor is how much longer than the previous delay a decorrelated delay may grow
+const decorrelatedJitterFactor = 3
+
+// backoff computes the delays between the retry attempts of a single request.
+// It is not safe for concurrent use, create one per retried request.
+type backoff struct {
+	base   time.Duration
+	max    time.Duration
+	jitter config.RetryJitter
+	prev   time.Duration
+}
+
+// newBackoff creates a backoff configured from the langfuse config
+func newBackoff(cfg *config.Langfuse) *backoff {
+	return &backoff{
+		base:   cfg.RetryDelay,
+		max:    cfg.MaxRetryDelay,
+		jitter: cfg.RetryJitter,
+	}
+}
+
+// next returns the delay before the given retry attempt, starting at 1 for the first retry.
+// The computed delay is capped at the max delay, a longer Retry-After carried by err is honored in full,
+// callers give up instead when it is longer than the max delay (see retryAfterExceeds).
+func (b *backoff) next(attempt int, err error) time.Duration {
+	var delay time.Duration
+	switch b.jitter {
+	case config.RetryJitterNone:
+		delay = b.exponential(attempt)
+	case config.RetryJitterDecorrelated:
+		prev := max(b.prev, b.base)
+		delay = b.base + randomDuration(b.capped(decorrelatedJitterFactor*float64(prev))-b.base)
+	default:
+		delay = randomDuration(b.exponential(attempt))
+	}
+
+	if retryAfter := retryAfterOf(err); retryAfter > delay {
+		delay = retryAfter
+	}
+	b.prev = delay
+	return delay
+}
+
+// retryAfterExceeds reports whether err carries a Retry-After longer than the max delay,
+// waiting that long is not allowed so the request is not retried
+func (b *backoff) retryAfterExceeds(err error) bool {
+	return b.max > 0 && retryAfterOf(err) > b.max
+}
+
+// exponential returns RetryDelay * 2^(attempt-1), capped at the max delay
+func (b *backoff) exponential(attempt int) time.Duration {
+	return b.capped(float64(b.base) * math.Pow(retryBackoffBase, float64(attempt-1)))
+}
+
+// capped converts the delay to a duration no longer than the max delay, guarding against overflow
+func (b *backoff) capped(delay float64) time.Duration {
+	if b.max > 0 && delay > float64(b.max) {
+		return b.max
+	}
+	if delay >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(delay)
+}
+
+// randomDuration returns a random duration in [0, d)
+func randomDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d) //nolint:gosec // jitter does not need a secure random source
+}
+
+// retryAfterOf returns the Retry-After delay requested by the server for err, zero if none
+func retryAfterOf(err error) time.Duration {
+	var langfuseErr *Error
+	if errors.As(err, &langfuseErr) {
+		return langfuseErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP-date.
+// Returns zero when the header is missing, malformed or in the past.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds <= 0 || seconds > int64(math.MaxInt64/time.Second) {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+const (
+	// retryBudgetReserve is the number of retries available before any request was made
+	retryBudgetReserve = 10
+	// retryBudgetCap is the maximum number of retries that can be saved up
+	retryBudgetCap = 100
+)
+
+// retryBudget limits retries to a ratio of first attempts, shared by all requests of a service,
+// so retrying cannot multiply the load on an API that is already failing. A nil budget is unlimited.
+type retryBudget struct {
+	ratio float64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+// newRetryBudget creates a budget allowing ratio retries per first attempt, nil when ratio is 0
+func newRetryBudget(ratio float64) *retryBudget {
+	if ratio <= 0 {
+		return nil
+	}
+	return &retryBudget{ratio: ratio, tokens: retryBudgetReserve}
+}
+
+// deposit records a first attempt, earning a fraction of a retry
+func (b *retryBudget) deposit() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(retryBudgetCap, b.tokens+b.ratio)
+}
+
+// withdraw reports whether a retry may be made, spending it from the budget
+func (b *retryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
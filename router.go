@@ -0,0 +1,207 @@
+package langfuse
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+
+	"github.com/xops-infra/GoLangfuse/config"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+const (
+	// DefaultDestination name of the project configured by config.Langfuse, receiving every event not routed elsewhere
+	DefaultDestination = "default"
+
+	// DestinationMetadataKey metadata key RouteByContext reads the destination name from
+	DestinationMetadataKey = "langfuse_destination"
+)
+
+// Destination a Langfuse project events can be routed to besides the one configured by config.Langfuse
+type Destination struct {
+	Name      string // Name identifies the destination in routing decisions and metrics
+	URL       string // URL the Langfuse server of the project, config.Langfuse.URL when empty
+	PublicKey string // PublicKey the public key of the project
+	SecretKey string // SecretKey the secret key of the project
+}
+
+// Router picks the name of the destination an event is sent to.
+// An empty name sends the event to DefaultDestination.
+type Router func(ctx context.Context, event types.LangfuseEvent) string
+
+// destinationKey context key of the destination name set by ContextWithDestination
+type destinationKey struct{}
+
+// ContextWithDestination returns a context routing the events submitted with it to the named destination
+//
+// Example:
+//
+//	ctx = langfuse.ContextWithDestination(ctx, tenant.ID)
+//	service.AddEvent(ctx, trace)
+func ContextWithDestination(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, destinationKey{}, name)
+}
+
+// DestinationFromContext returns the destination name set by ContextWithDestination, empty if none
+func DestinationFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(destinationKey{}).(string)
+	return name
+}
+
+// RouteByContext the default Router. It routes events to the destination set by ContextWithDestination,
+// falling back to the DestinationMetadataKey entry of the event metadata.
+func RouteByContext(ctx context.Context, event types.LangfuseEvent) string {
+	if name := DestinationFromContext(ctx); name != "" {
+		return name
+	}
+
+	var metadata map[string]any
+	switch e := event.(type) {
+	case *types.TraceEvent:
+		metadata = e.Metadata
+	case *types.SpanEvent:
+		metadata = e.Metadata
+	case *types.GenerationEvent:
+		metadata = e.Metadata
+	case *types.ScoreEvent:
+		metadata = e.Metadata
+	}
+	name, _ := metadata[DestinationMetadataKey].(string)
+	return name
+}
+
+// destination a project events are sent to, with its own exporter and circuit breaker
+type destination struct {
+	name     string
+	exporter Exporter
+	breaker  *circuitBreaker
+}
+
+// newDestinations creates the default destination from the service config and one destination per routed project.
+// The default destination uses the given exporter, or the Langfuse API when nil. Routed projects are sent to
+// through the API, sharing the HTTP client, retry budget and every other setting with the default one.
+func newDestinations(cfg *config.Langfuse, httpClient *http.Client, exporter Exporter, routed []Destination, retries *retryBudget, metrics *MetricsCollector) (map[string]*destination, error) {
+	destinations := make(map[string]*destination, len(routed)+1)
+	add := func(name string, exporter Exporter) {
+		breaker := newCircuitBreaker(cfg.CircuitBreakerThreshold, cfg.CircuitBreakerCooldown, func(state breakerState) {
+			if name == DefaultDestination {
+				metrics.UpdateCircuitBreakerState(state.String())
+			}
+			metrics.UpdateDestinationCircuitBreakerState(name, state.String())
+		})
+
+		if breaker != nil {
+			exporter = breakerExporter{Exporter: exporter, breaker: breaker}
+			breaker.onStateChange(breakerClosed)
+		}
+		destinations[name] = &destination{name: name, exporter: exporter, breaker: breaker}
+	}
+
+	if exporter == nil {
+		exporter = NewHTTPExporter(newClient(cfg, httpClient, retries, metrics))
+	}
+	add(DefaultDestination, exporter)
+	for _, routedTo := range routed {
+		if err := validateDestination(routedTo); err != nil {
+			return nil, err
+		}
+		if _, exists := destinations[routedTo.Name]; exists {
+			return nil, ErrInvalidConfig.WithDetails(map[string]any{"destination": routedTo.Name, "reason": "duplicate name"})
+		}
+
+		destinationConfig := *cfg
+		if routedTo.URL != "" {
+			destinationConfig.URL = routedTo.URL
+		}
+		destinationConfig.PublicKey = routedTo.PublicKey
+		destinationConfig.SecretKey = routedTo.SecretKey
+		add(routedTo.Name, NewHTTPExporter(newClient(&destinationConfig, httpClient, retries, metrics)))
+	}
+	return destinations, nil
+}
+
+// validateDestination checks the destination has a name, keys and a valid URL if set
+func validateDestination(d Destination) error {
+	details := map[string]any{"destination": d.Name}
+	switch {
+	case strings.TrimSpace(d.Name) == "":
+		return ErrInvalidConfig.WithDetails(map[string]any{"reason": "destination name is required"})
+	case d.PublicKey == "":
+		return ErrMissingPublicKey.WithDetails(details)
+	case d.SecretKey == "":
+		return ErrMissingSecretKey.WithDetails(details)
+	case d.URL != "" && !govalidator.IsURL(d.URL):
+		details["url"] = d.URL
+		return ErrInvalidConfig.WithDetails(details)
+	}
+	return nil
+}
+
+// route returns the destination of the event. Routing is skipped when no destinations were added,
+// events routed to a destination that does not exist are rejected rather than sent to the default project.
+func (l *langfuseService) route(ctx context.Context, event types.LangfuseEvent) (string, error) {
+	if len(l.destinations) == 1 {
+		return DefaultDestination, nil
+	}
+
+	name := l.router(ctx, event)
+	if name == "" {
+		return DefaultDestination, nil
+	}
+	if _, ok := l.destinations[name]; !ok {
+		return "", ErrUnknownDestination.WithDetails(map[string]any{"destination": name})
+	}
+	return name, nil
+}
+
+// holding reports whether every destination's circuit breaker holds events back, so there is nothing to send
+func (l *langfuseService) holding() bool {
+	for _, d := range l.destinations {
+		if !d.breaker.holding() {
+			return false
+		}
+	}
+	return true
+}
+
+// deliver sends the batch to the destinations of its events, one batch per destination in order of first appearance,
+// and returns the events held back by an open circuit breaker. Sending is cancelled along with ctx.
+func (l *langfuseService) deliver(ctx context.Context, batch []eventChanItem) (FlushResult, []eventChanItem) {
+	if len(l.destinations) == 1 {
+		result, held := l.sendBatch(ctx, l.destinations[DefaultDestination], batch)
+		l.metricsCollector.RecordDestinationResult(DefaultDestination, result.EventsSent, result.EventsFailed)
+		return result, held
+	}
+
+	var names []string
+	groups := make(map[string][]eventChanItem)
+	for _, item := range batch {
+		if _, ok := groups[item.destination]; !ok {
+			names = append(names, item.destination)
+		}
+		groups[item.destination] = append(groups[item.destination], item)
+	}
+
+	var result FlushResult
+	var held []eventChanItem
+	for _, name := range names {
+		items := groups[name]
+		d, ok := l.destinations[name]
+		if !ok {
+			// Replayed from a spool written while the destination still existed
+			for _, item := range items {
+				l.failEvent(item, ErrUnknownDestination.WithDetails(map[string]any{"destination": name}))
+			}
+			result.EventsFailed += len(items)
+			continue
+		}
+
+		destinationResult, destinationHeld := l.sendBatch(ctx, d, items)
+		l.metricsCollector.RecordDestinationResult(name, destinationResult.EventsSent, destinationResult.EventsFailed)
+		result.add(destinationResult)
+		held = append(held, destinationHeld...)
+	}
+	return result, held
+}
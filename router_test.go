@@ -0,0 +1,89 @@
+package langfuse_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	langfuse "github.com/xops-infra/GoLangfuse"
+	"github.com/xops-infra/GoLangfuse/mock"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+func Test_Destinations_ShouldSendEventsToTheRoutedProject(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.CircuitBreakerThreshold = 3
+	cfg.CircuitBreakerCooldown = time.Minute
+	var mu sync.Mutex
+	sent := make(map[string][]string) // host and public key to event names, one entry per request
+	transport := mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var request struct {
+			Batch []struct {
+				Body struct {
+					Name string `json:"name"`
+				} `json:"body"`
+			} `json:"batch"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return nil, err
+		}
+		publicKey, _, _ := req.BasicAuth()
+		var names []string
+		for _, event := range request.Batch {
+			names = append(names, event.Body.Name)
+		}
+		mu.Lock()
+		sent[req.URL.Host+" "+publicKey] = append(sent[req.URL.Host+" "+publicKey], strings.Join(names, ","))
+		mu.Unlock()
+		return newResponse(http.StatusOK, "{}"), nil
+	})
+	subject := newTestService(t, cfg, transport, langfuse.WithDestinations(
+		langfuse.Destination{Name: "acme", PublicKey: "pk-acme", SecretKey: "sk-acme"},
+		langfuse.Destination{Name: "globex", URL: "http://eu.localhost:3000", PublicKey: "pk-globex", SecretKey: "sk-globex"},
+	))
+
+	acme := langfuse.ContextWithDestination(context.TODO(), "acme")
+	subject.AddEvent(acme, &types.TraceEvent{Name: "acme-1"})
+	subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "default-1"})
+	subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "globex-1", Metadata: map[string]any{langfuse.DestinationMetadataKey: "globex"}})
+	subject.AddEvent(acme, &types.TraceEvent{Name: "acme-2"})
+
+	_, err := subject.SubmitEvent(langfuse.ContextWithDestination(context.TODO(), "initech"), &types.TraceEvent{Name: "unknown"})
+	require.ErrorIs(t, err, langfuse.ErrUnknownDestination)
+
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+	require.NoError(t, subject.Stop(context.TODO()))
+
+	assert.Equal(t, langfuse.FlushResult{EventsSent: 4}, result)
+	assert.Equal(t, map[string][]string{
+		"localhost:3000 pk-acme":           {"acme-1,acme-2"},
+		"localhost:3000 LangfusePublicKey": {"default-1"},
+		"eu.localhost:3000 pk-globex":      {"globex-1"},
+	}, sent)
+
+	destinations := subject.GetMetrics().Destinations
+	assert.Equal(t, int64(2), destinations["acme"].EventsProcessed)
+	assert.Equal(t, int64(1), destinations[langfuse.DefaultDestination].EventsProcessed)
+	assert.Equal(t, int64(1), destinations["globex"].EventsQueued)
+	assert.Equal(t, "closed", destinations["globex"].CircuitBreakerState)
+}
+
+func Test_Destinations_WithInvalidDestination_ShouldFail(t *testing.T) {
+	cfg := newTestConfig()
+
+	_, err := langfuse.NewWithClient(cfg, &http.Client{}, langfuse.WithDestinations(langfuse.Destination{Name: "acme", PublicKey: "pk-acme"}))
+	assert.ErrorIs(t, err, langfuse.ErrMissingSecretKey)
+
+	_, err = langfuse.NewWithClient(cfg, &http.Client{}, langfuse.WithDestinations(
+		langfuse.Destination{Name: langfuse.DefaultDestination, PublicKey: "pk", SecretKey: "sk"},
+	))
+	assert.ErrorIs(t, err, langfuse.ErrInvalidConfig)
+}
@@ -0,0 +1,151 @@
+package langfuse
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// sampledTraceCacheSize is how many traces the sampler remembers the decision for
+const sampledTraceCacheSize = 10000
+
+// SamplingRule sets the fraction of traces kept for the events it matches, rules are evaluated
+// in order and the first match wins. Empty fields match anything. Rules without a Level decide on
+// the trace-create event, the observations and scores of the trace follow that decision.
+// Rules with a Level further thin out the spans and generations of the traces kept.
+type SamplingRule struct {
+	Name        string      // Name the name of the trace, as set on TraceEvent.Name
+	Environment string      // Environment the environment of the trace, as set on TraceEvent.Environment
+	Level       types.Level // Level the level of the observation, only spans and generations have one
+	Rate        float64     // Rate fraction of matching traces kept, between 0 and 1
+}
+
+// matches reports whether the rule applies to the given trace name, environment and level
+func (r SamplingRule) matches(name, environment string, level types.Level) bool {
+	return (r.Name == "" || r.Name == name) &&
+		(r.Environment == "" || r.Environment == environment) &&
+		(r.Level == "" || r.Level == level)
+}
+
+// sampledTrace the decision made on a trace-create event, applied to the rest of the trace
+type sampledTrace struct {
+	name        string
+	environment string
+	kept        bool
+}
+
+// sampler decides which events are sent by hashing their trace ID against the rate of the matching rule,
+// so every replica decides alike. The decision made on a trace-create event is remembered for the most
+// recent traces and applied to their observations and scores, events of other traces are sampled
+// at the default rate. A nil sampler keeps every event.
+type sampler struct {
+	rate       float64
+	rules      []SamplingRule
+	keepErrors bool
+
+	// mu guards traces, a bounded cache evicting the oldest trace first
+	mu     sync.Mutex
+	traces map[string]sampledTrace
+	order  []string
+	next   int
+}
+
+// newSampler creates a sampler with the default rate and rules, nil when every event is kept anyway.
+// A zero rate is unset and keeps every trace.
+func newSampler(rate float64, rules []SamplingRule, keepErrors bool) *sampler {
+	if rate <= 0 {
+		rate = 1
+	}
+	if rate >= 1 && len(rules) == 0 {
+		return nil
+	}
+	return &sampler{
+		rate:       rate,
+		rules:      rules,
+		keepErrors: keepErrors,
+		traces:     make(map[string]sampledTrace),
+		order:      make([]string, sampledTraceCacheSize),
+	}
+}
+
+// keep reports whether the event is sent
+func (s *sampler) keep(event types.LangfuseEvent) bool {
+	if s == nil {
+		return true
+	}
+
+	traceID := traceKey(event)
+	var level types.Level
+	switch e := event.(type) {
+	case *types.TraceEvent:
+		trace := sampledTrace{name: e.Name, environment: e.Environment}
+		trace.kept = s.sampled(traceID, s.traceRate(trace.name, trace.environment))
+		s.remember(traceID, trace)
+		return trace.kept
+	case *types.SpanEvent:
+		level = e.Level
+	case *types.GenerationEvent:
+		level = e.Level
+	}
+
+	if s.keepErrors && level == types.Error {
+		return true
+	}
+
+	trace, ok := s.lookup(traceID)
+	if !ok {
+		trace.kept = s.sampled(traceID, s.rate)
+	}
+	if !trace.kept || level == "" {
+		return trace.kept
+	}
+	for _, rule := range s.rules {
+		if rule.Level != "" && rule.matches(trace.name, trace.environment, level) {
+			return s.sampled(traceID, rule.Rate)
+		}
+	}
+	return true
+}
+
+// traceRate returns the rate of the first rule without a level matching the trace, or the default rate
+func (s *sampler) traceRate(name, environment string) float64 {
+	for _, rule := range s.rules {
+		if rule.Level == "" && rule.matches(name, environment, "") {
+			return rule.Rate
+		}
+	}
+	return s.rate
+}
+
+// sampled reports whether the trace falls within the rate, the same trace ID always hashing to the same point
+func (s *sampler) sampled(traceID string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(traceID))
+	return float64(hash.Sum64()>>11)/(1<<53) < rate
+}
+
+// remember stores the decision made for the trace so its observations and scores follow it
+func (s *sampler) remember(traceID string, trace sampledTrace) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.traces[traceID]; !ok {
+		delete(s.traces, s.order[s.next])
+		s.order[s.next] = traceID
+		s.next = (s.next + 1) % len(s.order)
+	}
+	s.traces[traceID] = trace
+}
+
+// lookup returns the remembered trace, false when it was not seen or has been evicted
+func (s *sampler) lookup(traceID string) (sampledTrace, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trace, ok := s.traces[traceID]
+	return trace, ok
+}
@@ -0,0 +1,109 @@
+package langfuse_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	langfuse "github.com/xops-infra/GoLangfuse"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+func Test_Sampling_ShouldDropWholeTracesAtTheDefaultRateButKeepErrors(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.SampleRate = 1e-9
+	cfg.SampleKeepErrors = true
+	recorder := &requestRecorder{}
+	subject := newTestService(t, cfg, recorder, langfuse.WithSamplingRules(langfuse.SamplingRule{Name: "checkout", Rate: 1}))
+
+	healthCheckID := uuid.New()
+	healthCheckTraceID := healthCheckID.String()
+	for _, event := range []types.LangfuseEvent{
+		&types.TraceEvent{ID: &healthCheckID, Name: "health-check"},
+		&types.SpanEvent{TraceID: &healthCheckID, Name: "ping"},
+		&types.ScoreEvent{TraceID: &healthCheckTraceID, Name: "ok", Value: 1},
+		&types.SpanEvent{TraceID: &healthCheckID, Name: "failed-ping", Level: types.Error},
+		&types.TraceEvent{Name: "checkout"},
+	} {
+		require.NotNil(t, subject.AddEvent(context.TODO(), event))
+	}
+
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+
+	assert.Equal(t, langfuse.FlushResult{EventsSent: 2}, result)
+	assert.Equal(t, int64(3), subject.GetMetrics().EventsSampledOut)
+	requests := recorder.requests()
+	require.Len(t, requests, 1)
+	assert.Contains(t, requests[0], `"name":"failed-ping"`)
+	assert.Contains(t, requests[0], `"name":"checkout"`)
+}
+
+func Test_Sampling_ShouldDropTheObservationsAndScoresOfATraceDroppedByARule(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.SampleKeepErrors = true
+	recorder := &requestRecorder{}
+	subject := newTestService(t, cfg, recorder, langfuse.WithSamplingRules(
+		langfuse.SamplingRule{Name: "health-check", Rate: 0},
+		langfuse.SamplingRule{Level: types.Debug, Rate: 0},
+	))
+
+	healthCheckID := uuid.New()
+	healthCheckTraceID := healthCheckID.String()
+	checkoutID := uuid.New()
+	checkoutTraceID := checkoutID.String()
+	for _, event := range []types.LangfuseEvent{
+		&types.TraceEvent{ID: &healthCheckID, Name: "health-check"},
+		&types.SpanEvent{TraceID: &healthCheckID, Name: "ping"},
+		&types.ScoreEvent{TraceID: &healthCheckTraceID, Name: "ok", Value: 1},
+		&types.SpanEvent{TraceID: &healthCheckID, Name: "failed-ping", Level: types.Error},
+		&types.TraceEvent{ID: &checkoutID, Name: "checkout"},
+		&types.SpanEvent{TraceID: &checkoutID, Name: "pay"},
+		&types.SpanEvent{TraceID: &checkoutID, Name: "details", Level: types.Debug},
+		&types.ScoreEvent{TraceID: &checkoutTraceID, Name: "paid", Value: 1},
+	} {
+		require.NotNil(t, subject.AddEvent(context.TODO(), event))
+	}
+
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+
+	assert.Equal(t, langfuse.FlushResult{EventsSent: 4}, result)
+	assert.Equal(t, int64(4), subject.GetMetrics().EventsSampledOut)
+	requests := recorder.requests()
+	require.Len(t, requests, 1)
+	for _, name := range []string{"health-check", "ping", "ok", "details"} {
+		assert.NotContains(t, requests[0], `"name":"`+name+`"`)
+	}
+	for _, name := range []string{"failed-ping", "checkout", "pay", "paid"} {
+		assert.Contains(t, requests[0], `"name":"`+name+`"`)
+	}
+}
+
+func Test_Sampling_WithZeroSampleRate_ShouldKeepEveryTrace(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.SampleRate = 0
+	recorder := &requestRecorder{}
+	subject := newTestService(t, cfg, recorder)
+
+	for range 10 {
+		require.NotNil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "example"}))
+	}
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+
+	assert.Equal(t, langfuse.FlushResult{EventsSent: 10}, result)
+	assert.Zero(t, subject.GetMetrics().EventsSampledOut)
+}
+
+func Test_Sampling_WithNegativeSampleRate_ShouldRejectConfig(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.SampleRate = -0.5
+
+	_, err := langfuse.NewWithClient(cfg, &http.Client{})
+	assert.ErrorContains(t, err, "sample rate")
+}
@@ -0,0 +1,554 @@
+package langfuse
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xops-infra/GoLangfuse/logger"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// This file contains the write-ahead spool used to persist events on disk until they are delivered.
+//
+// The spool is a directory of append-only segment files. Every accepted event is written as an append
+// record before it is queued, and an ack record is written once the event is delivered or given up on.
+// On start-up all segments are read back and events without an ack are replayed. Segments are deleted
+// oldest first once every event appended to them has been acknowledged. When the active segment is rotated
+// and the oldest segment is only held by a few unacknowledged events, their append records are copied
+// into the active segment so a single stuck event does not keep every later segment on disk.
+//
+// Record layout: 4 bytes payload length | 4 bytes CRC32 of kind and payload | 1 byte kind | payload.
+// Records are written without fsync, so they survive a process crash but not necessarily a host crash.
+
+const (
+	defaultSpoolMaxBytes     = 256 << 20 // 256MB across all segments
+	defaultSpoolSegmentBytes = 16 << 20  // 16MB per segment
+	maxSpoolRecordBytes      = 64 << 20  // records larger than this are treated as corruption
+
+	spoolSegmentExt   = ".wal"
+	spoolHeaderSize   = 9
+	spoolRecordAppend = byte(1)
+	spoolRecordAck    = byte(2)
+
+	spoolCompactRatio = 4 // the oldest segment is compacted once at most 1 in spoolCompactRatio of its events is pending
+)
+
+// spooledEvent an event as persisted in an append record
+type spooledEvent struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Body        json.RawMessage `json:"body"`
+	Destination string          `json:"destination,omitempty"`
+}
+
+// replayedEvent an unacknowledged event read back from the spool with the destination it was routed to
+type replayedEvent struct {
+	event       types.LangfuseEvent
+	destination string
+}
+
+// spoolSegment bookkeeping for a single segment file
+type spoolSegment struct {
+	seq      uint64
+	size     int64
+	appended int // number of events appended
+	pending  int // number of appended events not acknowledged yet
+}
+
+// spool a disk-backed write-ahead log of events waiting to be delivered
+type spool struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	mu         sync.Mutex
+	active     *os.File
+	segments   []*spoolSegment     // ordered oldest first, the last one is the active segment
+	pending    map[string][]uint64 // event ID to segments holding its unacknowledged appends
+	totalBytes int64
+	compacting bool // compacting set while the oldest segment is copied, its writes may rotate again
+}
+
+// spoolReplay result of reading an existing spool directory
+type spoolReplay struct {
+	events  []replayedEvent // events appended but never acknowledged, in append order
+	corrupt int             // number of unreadable records or segment tails that were skipped
+}
+
+// openSpool opens or creates the spool in dir and returns the events that still need to be delivered
+func openSpool(dir string, maxBytes, segmentBytes int64) (*spool, spoolReplay, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultSpoolMaxBytes
+	}
+	if segmentBytes <= 0 {
+		segmentBytes = defaultSpoolSegmentBytes
+	}
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, spoolReplay{}, ErrInvalidConfig.WithCause(err).WithDetails(map[string]any{"spool_dir": dir})
+	}
+
+	s := &spool{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: segmentBytes,
+		pending:      make(map[string][]uint64),
+	}
+
+	replay, err := s.load()
+	if err != nil {
+		return nil, spoolReplay{}, err
+	}
+
+	s.trimAcknowledged()
+
+	// Always start a fresh segment, the tail of the previous one may be torn
+	var nextSeq uint64 = 1
+	if len(s.segments) > 0 {
+		nextSeq = s.segments[len(s.segments)-1].seq + 1
+	}
+	if err := s.openSegment(nextSeq); err != nil {
+		return nil, spoolReplay{}, err
+	}
+
+	return s, replay, nil
+}
+
+// load reads all existing segments, rebuilding the pending index and collecting unacknowledged events
+func (s *spool) load() (spoolReplay, error) {
+	var replay spoolReplay
+
+	seqs, err := s.listSegments()
+	if err != nil {
+		return replay, err
+	}
+
+	// appended events in order, nil once acknowledged
+	type appended struct {
+		seq   uint64
+		event *spooledEvent
+	}
+	var appends []*appended
+	byID := make(map[string][]int)
+
+	for _, seq := range seqs {
+		segment := &spoolSegment{seq: seq}
+		s.segments = append(s.segments, segment)
+
+		records, size, corrupt := readSpoolSegment(s.segmentPath(seq))
+		segment.size = size
+		s.totalBytes += size
+		replay.corrupt += corrupt
+
+		for _, rec := range records {
+			switch rec.kind {
+			case spoolRecordAppend:
+				var event spooledEvent
+				if err := json.Unmarshal(rec.payload, &event); err != nil {
+					replay.corrupt++
+					continue
+				}
+				byID[event.ID] = append(byID[event.ID], len(appends))
+				appends = append(appends, &appended{seq: seq, event: &event})
+				segment.appended++
+				segment.pending++
+
+			case spoolRecordAck:
+				id := string(rec.payload)
+				indexes := byID[id]
+				if len(indexes) == 0 {
+					continue
+				}
+				byID[id] = indexes[1:]
+				acked := appends[indexes[0]]
+				s.segment(acked.seq).pending--
+				acked.event = nil
+			}
+		}
+	}
+
+	for _, entry := range appends {
+		if entry.event == nil {
+			continue
+		}
+
+		event, err := decodeEvent(entry.event.Type, entry.event.Body)
+		if err != nil {
+			replay.corrupt++
+			s.segment(entry.seq).pending--
+			continue
+		}
+
+		s.pending[entry.event.ID] = append(s.pending[entry.event.ID], entry.seq)
+		replay.events = append(replay.events, replayedEvent{event: event, destination: entry.event.Destination})
+	}
+
+	return replay, nil
+}
+
+// append persists the event and its destination, returns ErrSpoolFull when the spool size limit would be exceeded
+func (s *spool) append(event types.LangfuseEvent, destination string) error {
+	if s == nil {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "spool_encode"})
+	}
+
+	id := event.GetID().String()
+	payload, err := json.Marshal(spooledEvent{ID: id, Type: getEventType(event), Body: body, Destination: destination})
+	if err != nil {
+		return ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "spool_encode"})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recordSize := int64(spoolHeaderSize + len(payload))
+	if s.totalBytes+recordSize > s.maxBytes {
+		return ErrSpoolFull.WithDetails(map[string]any{"max_bytes": s.maxBytes})
+	}
+
+	if err := s.write(spoolRecordAppend, payload); err != nil {
+		return err
+	}
+
+	active := s.segments[len(s.segments)-1]
+	active.appended++
+	active.pending++
+	s.pending[id] = append(s.pending[id], active.seq)
+	return nil
+}
+
+// ack marks one pending append of the event as done so it is not replayed again
+func (s *spool) ack(event types.LangfuseEvent) error {
+	if s == nil || event.GetID() == nil {
+		return nil
+	}
+
+	id := event.GetID().String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seqs := s.pending[id]
+	if len(seqs) == 0 {
+		return nil
+	}
+
+	// Ack records are always written, even over the size limit, otherwise events would be replayed forever
+	if err := s.write(spoolRecordAck, []byte(id)); err != nil {
+		return err
+	}
+
+	if len(seqs) == 1 {
+		delete(s.pending, id)
+	} else {
+		s.pending[id] = seqs[1:]
+	}
+	s.segment(seqs[0]).pending--
+	s.trimAcknowledged()
+	return nil
+}
+
+// size returns the total number of bytes held by the spool segments
+func (s *spool) size() int64 {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.totalBytes
+}
+
+// close closes the active segment, the spool must not be used afterwards
+func (s *spool) close() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active.Close()
+}
+
+// write appends a record to the active segment, rotating it first when it is full. Must be called with mu held.
+func (s *spool) write(kind byte, payload []byte) error {
+	active := s.segments[len(s.segments)-1]
+	recordSize := int64(spoolHeaderSize + len(payload))
+	if active.size > 0 && active.size+recordSize > s.segmentBytes {
+		if err := s.active.Close(); err != nil {
+			return ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "spool_rotate"})
+		}
+		if err := s.openSegment(active.seq + 1); err != nil {
+			return err
+		}
+		s.compactOldest()
+		s.trimAcknowledged()
+		active = s.segments[len(s.segments)-1]
+	}
+
+	record := make([]byte, recordSize)
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload))) //nolint:gosec // bounded by maxSpoolRecordBytes on read
+	record[8] = kind
+	copy(record[spoolHeaderSize:], payload)
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(record[8:]))
+
+	if _, err := s.active.Write(record); err != nil {
+		return ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "spool_write"})
+	}
+
+	active.size += recordSize
+	s.totalBytes += recordSize
+	return nil
+}
+
+// openSegment creates a new active segment with the given sequence number. Must be called with mu held.
+func (s *spool) openSegment(seq uint64) error {
+	file, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "spool_open"})
+	}
+
+	s.active = file
+	s.segments = append(s.segments, &spoolSegment{seq: seq})
+	return nil
+}
+
+// trimAcknowledged deletes the oldest segments whose events were all acknowledged.
+// Segments are only removed in order so an append record is never left on disk without the newer ack record covering it.
+func (s *spool) trimAcknowledged() {
+	for len(s.segments) > 0 && s.segments[0].pending <= 0 {
+		oldest := s.segments[0]
+		if s.active != nil && oldest == s.segments[len(s.segments)-1] {
+			return // never remove the active segment
+		}
+
+		if err := os.Remove(s.segmentPath(oldest.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		s.totalBytes -= oldest.size
+		s.segments = s.segments[1:]
+	}
+}
+
+// compactOldest copies the pending appends of the oldest segment into the active segment when only a few of
+// its events are pending, so it can be deleted. Must be called with mu held.
+func (s *spool) compactOldest() {
+	if s.compacting || len(s.segments) < 2 {
+		return
+	}
+	oldest := s.segments[0]
+	if oldest.pending <= 0 || oldest.pending*spoolCompactRatio > oldest.appended {
+		return
+	}
+
+	s.compacting = true
+	defer func() { s.compacting = false }()
+
+	records, _, _ := readSpoolSegment(s.segmentPath(oldest.seq))
+	for _, rec := range records {
+		if rec.kind != spoolRecordAppend {
+			continue
+		}
+		var event spooledEvent
+		if err := json.Unmarshal(rec.payload, &event); err != nil {
+			continue
+		}
+		seqs := s.pending[event.ID]
+		index := slices.Index(seqs, oldest.seq)
+		if index < 0 {
+			continue // acknowledged
+		}
+
+		if err := s.write(spoolRecordAppend, rec.payload); err != nil {
+			return
+		}
+		active := s.segments[len(s.segments)-1]
+		active.appended++
+		active.pending++
+		oldest.pending--
+		s.pending[event.ID] = append(slices.Delete(seqs, index, index+1), active.seq)
+	}
+}
+
+// segment returns the bookkeeping entry of the segment with the given sequence number
+func (s *spool) segment(seq uint64) *spoolSegment {
+	for _, segment := range s.segments {
+		if segment.seq == seq {
+			return segment
+		}
+	}
+	return &spoolSegment{seq: seq}
+}
+
+// listSegments returns the sequence numbers of the segment files in the spool directory, oldest first
+func (s *spool) listSegments() ([]uint64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, ErrEventProcessing.WithCause(err).WithDetails(map[string]any{"operation": "spool_list"})
+	}
+
+	var seqs []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+// segmentPath returns the file path of the segment with the given sequence number
+func (s *spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentExt))
+}
+
+// spoolRecord a record read back from a segment
+type spoolRecord struct {
+	kind    byte
+	payload []byte
+}
+
+// readSpoolSegment reads all valid records of a segment. Reading stops at the first torn or corrupted
+// record since its length cannot be trusted, the rest of the segment is counted as one corrupt entry.
+func readSpoolSegment(path string) ([]spoolRecord, int64, int) {
+	file, err := os.Open(path) //nolint:gosec // path is built from the configured spool directory
+	if err != nil {
+		return nil, 0, 1
+	}
+	defer file.Close()
+
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+
+	var records []spoolRecord
+	header := make([]byte, spoolHeaderSize)
+	for {
+		if _, err := io.ReadFull(file, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return records, size, 0
+			}
+			return records, size, 1
+		}
+
+		length := binary.LittleEndian.Uint32(header[0:4])
+		if length > maxSpoolRecordBytes {
+			return records, size, 1
+		}
+
+		data := make([]byte, 1+length)
+		data[0] = header[8]
+		if _, err := io.ReadFull(file, data[1:]); err != nil {
+			return records, size, 1
+		}
+
+		if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(header[4:8]) {
+			return records, size, 1
+		}
+
+		records = append(records, spoolRecord{kind: data[0], payload: data[1:]})
+	}
+}
+
+// persist writes the event to the spool when spooling is enabled and reports whether it was persisted.
+// Events are still queued in memory when the spool is full or cannot be written.
+func (l *langfuseService) persist(ctx context.Context, event types.LangfuseEvent, destination string) bool {
+	if l.spool == nil {
+		return false
+	}
+
+	if err := l.spool.append(event, destination); err != nil {
+		logger.FromContext(ctx).WithError(err).Warnf("failed to spool langfuse event %s, keeping it in memory only", event.GetID())
+		return false
+	}
+
+	l.metricsCollector.UpdateSpoolSize(l.spool.size())
+	return true
+}
+
+// acknowledge marks a spooled event, and the events coalesced into it, as handled so they are not replayed on the next start
+func (l *langfuseService) acknowledge(item eventChanItem) {
+	for _, folded := range item.coalesced {
+		l.acknowledge(folded)
+	}
+	if !item.spooled {
+		return
+	}
+
+	if err := l.spool.ack(item.event); err != nil {
+		logger.FromContext(item.ctx).WithError(err).Warnf("failed to acknowledge spooled langfuse event %s", item.event.GetID())
+		return
+	}
+
+	l.metricsCollector.UpdateSpoolSize(l.spool.size())
+}
+
+// startSpoolReplay queues the events recovered from the spool in the background.
+// Replayed events wait for free queue space instead of being dropped, the ones not queued before Stop
+// stay in the spool for the next start.
+func (l *langfuseService) startSpoolReplay(events []replayedEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	log := logger.FromContext(context.Background())
+	log.Infof("replaying %d undelivered langfuse events from spool", len(events))
+
+	if l.buffer != nil {
+		l.replayBuffered(events)
+		return
+	}
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for i, replayed := range events {
+			item := l.replayedItem(replayed)
+			if err := l.queueFor(item.event).putWait(context.Background(), item); err != nil {
+				log.WithError(err).Warnf("stopped replaying spooled langfuse events, %d left for the next start", len(events)-i)
+				return
+			}
+			l.metricsCollector.IncrementEventsQueued()
+			l.metricsCollector.IncrementDestinationEventsQueued(item.destination)
+		}
+	}()
+}
+
+// replayedItem creates the queue item of an event recovered from the spool
+func (l *langfuseService) replayedItem(replayed replayedEvent) eventChanItem {
+	item := l.newItem(context.Background(), replayed.event)
+	item.spooled = true
+	item.destination = replayed.destination
+	if item.destination == "" {
+		item.destination = DefaultDestination
+	}
+	if err := l.measure(&item); err != nil {
+		logger.FromContext(item.ctx).WithError(err).Warnf("failed to measure spooled langfuse event %s", replayed.event.GetID())
+	}
+	return item
+}
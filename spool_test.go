@@ -0,0 +1,107 @@
+package langfuse_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xops-infra/GoLangfuse/config"
+	"github.com/xops-infra/GoLangfuse/mock"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+func Test_Spool_ShouldReplayUndeliveredEventsOnNextStart(t *testing.T) {
+	cfg := spoolConfig(t.TempDir())
+
+	// First instance cannot reach langfuse, events stay in the spool
+	subject := newTestService(t, cfg, statusTransport(http.StatusServiceUnavailable))
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "first"}))
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "second"}))
+	require.NoError(t, subject.Stop(context.TODO()))
+	assert.Equal(t, int64(2), subject.GetMetrics().EventsFailed)
+
+	// Second instance replays and delivers them
+	subject = newTestService(t, cfg, statusTransport(http.StatusOK))
+	assert.Equal(t, int64(2), subject.GetMetrics().EventsReplayed)
+	assert.Eventually(t, func() bool {
+		return subject.GetMetrics().EventsProcessed == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, subject.Stop(context.TODO()))
+
+	// Third instance has nothing left to replay
+	subject = newTestService(t, cfg, statusTransport(http.StatusOK))
+	assert.Equal(t, int64(0), subject.GetMetrics().EventsReplayed)
+}
+
+func Test_Spool_ShouldSkipCorruptedSegmentTail(t *testing.T) {
+	spoolDir := t.TempDir()
+	cfg := spoolConfig(spoolDir)
+
+	subject := newTestService(t, cfg, statusTransport(http.StatusServiceUnavailable))
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "kept"}))
+	require.NoError(t, subject.Stop(context.TODO()))
+
+	// Simulate a torn write at the end of the segment
+	segments, err := filepath.Glob(filepath.Join(spoolDir, "*.wal"))
+	require.NoError(t, err)
+	require.NotEmpty(t, segments)
+	file, err := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0x10, 0x00, 0x00, 0x00, 0xde, 0xad})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	subject = newTestService(t, cfg, statusTransport(http.StatusOK))
+	metrics := subject.GetMetrics()
+	assert.Equal(t, int64(1), metrics.EventsReplayed)
+	assert.Equal(t, int64(1), metrics.SpoolCorruptRecords)
+}
+
+func Test_Spool_ShouldCompactSegmentsHeldByAStuckEvent(t *testing.T) {
+	spoolDir := t.TempDir()
+	cfg := spoolConfig(spoolDir)
+	cfg.BatchSize = 1
+	cfg.SpoolSegmentBytes = 2048
+
+	// Only the stuck event keeps failing, every other event is delivered and acknowledged
+	subject := newTestService(t, cfg, mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		if strings.Contains(string(body), "stuck") {
+			return newResponse(http.StatusServiceUnavailable, "{}"), nil
+		}
+		return newResponse(http.StatusOK, "{}"), nil
+	}))
+
+	require.NotNil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "stuck"}))
+	for range 200 {
+		require.NotNil(t, subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "delivered"}))
+		_, err := subject.Flush(context.TODO())
+		require.NoError(t, err)
+	}
+
+	segments, err := filepath.Glob(filepath.Join(spoolDir, "*.wal"))
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(segments), 3, "the stuck event must not keep every segment on disk")
+	assert.Less(t, subject.GetMetrics().SpoolSize, int64(3*cfg.SpoolSegmentBytes))
+	require.NoError(t, subject.Stop(context.TODO()))
+
+	// The stuck event is still replayed after being copied
+	subject = newTestService(t, cfg, statusTransport(http.StatusOK))
+	assert.Equal(t, int64(1), subject.GetMetrics().EventsReplayed)
+}
+
+// spoolConfig returns a config spooling to the directory, sending batches shortly after events were added
+func spoolConfig(spoolDir string) *config.Langfuse {
+	cfg := newTestConfig()
+	cfg.BatchTimeout = 10 * time.Millisecond
+	cfg.SpoolDir = spoolDir
+	return cfg
+}
@@ -0,0 +1,79 @@
+package langfuse
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xops-infra/GoLangfuse/config"
+	"github.com/xops-infra/GoLangfuse/logger"
+)
+
+// syncBuffer holds the events of a service in sync mode until the caller sends them,
+// see config.Langfuse.SyncMode
+type syncBuffer struct {
+	mu       sync.Mutex
+	batch    *pendingBatch
+	capacity int // capacity events buffered at most, held back events included
+}
+
+// newSyncBuffer creates the buffer of a service in sync mode
+func newSyncBuffer(cfg *config.Langfuse) *syncBuffer {
+	capacity := cfg.QueueCapacity
+	if capacity <= 0 {
+		capacity = defaultQueueCapacity
+	}
+	return &syncBuffer{batch: newPendingBatch(capacity), capacity: capacity}
+}
+
+// bufferEvent adds the item to the buffer, sending the batch inline with ctx once it reaches the batch size
+func (l *langfuseService) bufferEvent(ctx context.Context, item eventChanItem) error {
+	l.buffer.mu.Lock()
+	defer l.buffer.mu.Unlock()
+
+	// Checked under the lock so no event is added after Stop sent the buffer
+	if l.currentState() != stateRunning {
+		return ErrServiceStopped.WithDetails(map[string]any{"state": l.currentState().String()})
+	}
+	if len(l.buffer.batch.items) >= l.buffer.capacity {
+		l.metricsCollector.IncrementEventsDropped()
+		return ErrQueueFull.WithDetails(map[string]any{"capacity": l.buffer.capacity, "sync_mode": true})
+	}
+
+	l.metricsCollector.IncrementEventsQueued()
+	l.metricsCollector.IncrementDestinationEventsQueued(item.destination)
+	l.add(ctx, l.buffer.batch, item)
+	return nil
+}
+
+// flushBuffer sends the buffered events inline, events held back by an open circuit breaker stay buffered
+func (l *langfuseService) flushBuffer(ctx context.Context) FlushResult {
+	l.buffer.mu.Lock()
+	defer l.buffer.mu.Unlock()
+
+	result := l.flush(ctx, l.buffer.batch)
+	l.updateQueueMetrics()
+	return result
+}
+
+// stopSync stops accepting events, sends the buffered events inline and releases the resources of the service
+func (l *langfuseService) stopSync(ctx context.Context) {
+	l.buffer.mu.Lock()
+	l.setState(stateDraining)
+	l.abandon(ctx, l.buffer.batch)
+	l.updateQueueMetrics()
+	l.buffer.mu.Unlock()
+
+	l.finishShutdown()
+}
+
+// replayBuffered adds the events recovered from the spool to the buffer, sending full batches inline
+func (l *langfuseService) replayBuffered(events []replayedEvent) {
+	log := logger.FromContext(context.Background())
+	for i, replayed := range events {
+		item := l.replayedItem(replayed)
+		if err := l.bufferEvent(context.Background(), item); err != nil {
+			log.WithError(err).Warnf("stopped replaying spooled langfuse events, %d left for the next start", len(events)-i)
+			return
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package langfuse_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	langfuse "github.com/xops-infra/GoLangfuse"
+	"github.com/xops-infra/GoLangfuse/mock"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+func Test_SyncMode_ShouldSendInlineOnFullBatchFlushAndStop(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BatchSize = 2
+	cfg.BatchTimeout = time.Millisecond
+	cfg.SyncMode = true
+	// No locking, requests are only ever sent by the calling goroutine
+	var batches []int
+	transport := mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var request struct {
+			Batch []json.RawMessage `json:"batch"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return nil, err
+		}
+		batches = append(batches, len(request.Batch))
+		return newResponse(http.StatusOK, "{}"), nil
+	})
+	subject := newTestService(t, cfg, transport)
+
+	subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "invocation-1"})
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, batches, "nothing is sent before the buffer is full or flushed")
+
+	subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "invocation-1"})
+	assert.Equal(t, []int{2}, batches, "a full buffer is sent inline")
+
+	subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "invocation-2"})
+	result, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, langfuse.FlushResult{EventsSent: 1}, result)
+	assert.Equal(t, []int{2, 1}, batches)
+
+	metrics := subject.GetMetrics()
+	assert.True(t, metrics.SyncMode)
+	assert.Zero(t, metrics.ActiveProcessors)
+	assert.Equal(t, int64(3), metrics.EventsProcessed)
+	assert.Equal(t, langfuse.ComponentHealthValue("healthy"), subject.CheckHealth(context.TODO()).ProcessorHealth)
+
+	subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "invocation-3"})
+	require.NoError(t, subject.Stop(context.TODO()))
+	assert.Equal(t, []int{2, 1, 1}, batches, "Stop sends what is left inline")
+
+	_, err = subject.SubmitEvent(context.TODO(), &types.TraceEvent{Name: "late"})
+	assert.ErrorIs(t, err, langfuse.ErrServiceStopped)
+}
+
+func Test_SyncMode_ShouldDropEventsWhenBufferIsFull(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BatchSize = 2
+	cfg.QueueCapacity = 3
+	cfg.CircuitBreakerThreshold = 1
+	cfg.CircuitBreakerCooldown = time.Hour
+	cfg.SyncMode = true
+	subject := newTestService(t, cfg, statusTransport(http.StatusServiceUnavailable))
+
+	// The first full batch fails and opens the breaker, later events are held back in the buffer
+	for range 5 {
+		subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "unreachable"})
+	}
+	_, err := subject.SubmitEvent(context.TODO(), &types.TraceEvent{Name: "unreachable"})
+	assert.ErrorIs(t, err, langfuse.ErrQueueFull)
+
+	metrics := subject.GetMetrics()
+	assert.Equal(t, int64(2), metrics.EventsFailed)
+	assert.Equal(t, int64(1), metrics.EventsDropped)
+	assert.Equal(t, 3, metrics.QueueSize)
+}
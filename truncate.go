@@ -0,0 +1,94 @@
+package langfuse
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+// TruncationLimits limits the size of values inside the Input, Output and Metadata of an event.
+// Truncated values stay valid JSON: strings end with a marker carrying their original size
+// and arrays get a trailing marker element carrying their original length.
+type TruncationLimits struct {
+	MaxStringBytes int // MaxStringBytes bytes kept of a string, cut at a character boundary, 0 for no limit
+	MaxArrayItems  int // MaxArrayItems elements kept of an array, 0 for no limit
+}
+
+// enabled reports whether any limit is set
+func (t TruncationLimits) enabled() bool {
+	return t.MaxStringBytes > 0 || t.MaxArrayItems > 0
+}
+
+// truncator applies truncation limits to events, per event type or the defaults
+type truncator struct {
+	defaults TruncationLimits
+	perType  map[string]TruncationLimits
+}
+
+// newTruncator creates a truncator, nil when no limits are set at all
+func newTruncator(defaults TruncationLimits, perType map[string]TruncationLimits) *truncator {
+	if !defaults.enabled() && len(perType) == 0 {
+		return nil
+	}
+	return &truncator{defaults: defaults, perType: perType}
+}
+
+// truncate returns a copy of the event with its payload truncated and the number of values truncated
+func (t *truncator) truncate(event types.LangfuseEvent) (types.LangfuseEvent, int) {
+	limits, ok := t.perType[getEventType(event)]
+	if !ok {
+		limits = t.defaults
+	}
+	if !limits.enabled() {
+		return event, 0
+	}
+
+	truncated := 0
+	var visit payloadVisitor
+	visit = func(path string, value any) (any, walkAction) {
+		switch v := value.(type) {
+		case string:
+			if limits.MaxStringBytes > 0 && len(v) > limits.MaxStringBytes {
+				truncated++
+				return truncateString(v, limits.MaxStringBytes), walkKeep
+			}
+		case []any:
+			if limits.MaxArrayItems > 0 && len(v) > limits.MaxArrayItems {
+				truncated++
+				// The kept elements are walked here so the marker itself is never truncated
+				kept := make([]any, 0, limits.MaxArrayItems+1)
+				for _, item := range v[:limits.MaxArrayItems] {
+					if item, ok := walkPayload(path, item, visit); ok {
+						kept = append(kept, item)
+					}
+				}
+				return append(kept, fmt.Sprintf("[truncated, original length %d items]", len(v))), walkKeep
+			}
+		}
+		return value, walkDescend
+	}
+	event = rewritePayload(event, visit)
+	return event, truncated
+}
+
+// truncateString keeps at most maxBytes bytes of whole characters followed by the truncation marker
+func truncateString(value string, maxBytes int) string {
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s...[truncated, original size %d bytes]", value[:cut], len(value))
+}
+
+// truncate returns the event with the truncation limits applied and records the truncated values
+func (l *langfuseService) truncate(event types.LangfuseEvent) types.LangfuseEvent {
+	if l.truncator == nil {
+		return event
+	}
+	event, truncated := l.truncator.truncate(event)
+	if truncated > 0 {
+		l.metricsCollector.RecordTruncations(truncated)
+	}
+	return event
+}
@@ -0,0 +1,61 @@
+package langfuse_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	langfuse "github.com/xops-infra/GoLangfuse"
+	"github.com/xops-infra/GoLangfuse/mock"
+	"github.com/xops-infra/GoLangfuse/types"
+)
+
+func Test_Truncation_ShouldTruncatePayloadsPerEventType(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.MaxStringBytes = 1000
+	var mu sync.Mutex
+	inputs := make(map[string]json.RawMessage)
+	transport := mock.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var request struct {
+			Batch []struct {
+				Type string `json:"type"`
+				Body struct {
+					Input json.RawMessage `json:"input"`
+				} `json:"body"`
+			} `json:"batch"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		for _, event := range request.Batch {
+			inputs[event.Type] = event.Body.Input
+		}
+		mu.Unlock()
+		return newResponse(http.StatusOK, "{}"), nil
+	})
+	subject := newTestService(t, cfg, transport,
+		langfuse.WithTruncationLimits("generation-create", langfuse.TruncationLimits{MaxStringBytes: 10, MaxArrayItems: 2}))
+
+	prompt := []string{"héllo wörld, a long document", "b", "c", "d"}
+	subject.AddEvent(context.TODO(), &types.GenerationEvent{Name: "rag", Input: prompt})
+	subject.AddEvent(context.TODO(), &types.TraceEvent{Name: "rag", Input: "héllo wörld, a long document"})
+
+	_, err := subject.Flush(context.TODO())
+	require.NoError(t, err)
+	require.NoError(t, subject.Stop(context.TODO()))
+
+	assert.JSONEq(t, `[
+		"héllo wö...[truncated, original size 30 bytes]",
+		"b",
+		"[truncated, original length 4 items]"
+	]`, string(inputs["generation-create"]))
+	assert.JSONEq(t, `"héllo wörld, a long document"`, string(inputs["trace-create"]))
+	assert.Equal(t, "héllo wörld, a long document", prompt[0], "the caller's values must not be modified")
+	assert.Equal(t, int64(2), subject.GetMetrics().ValuesTruncated)
+}